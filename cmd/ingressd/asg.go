@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maximum number of groups returned by a single DescribeAutoScalingGroups page
+	asgMaxRecords = 100
+
+	// maximum number of values of a single ec2 filter
+	ec2MaxFilterValues = 200
+)
+
+// asgDiscoverer discovers the ip addrs of the in service instances of one or
+// more auto scaling groups
+type asgDiscoverer struct {
+	asg asgDescriber
+	ec2 ec2Describer
+
+	// names of the auto scaling groups
+	groups []string
+
+	// ipv4 addr published for each instance: public, private or eip
+	address string
+
+	// only publish the addrs of the network interface attached at this device index
+	deviceIndex *int64
+}
+
+// newASGDiscoverer creates an auto scaling group discoverer for a source
+func newASGDiscoverer(asgClient asgDescriber, ec2Client ec2Describer, src asgSourceConfig) *asgDiscoverer {
+	return &asgDiscoverer{
+		asg:         asgClient,
+		ec2:         ec2Client,
+		groups:      src.Names,
+		address:     src.Address,
+		deviceIndex: src.DeviceIndex,
+	}
+}
+
+// Discover lists the in service instances of each auto scaling group, then
+// queries ec2 for their addrs, returning a target for each of the addrs chosen
+// by the source
+func (d *asgDiscoverer) Discover(ctx context.Context) ([]target, error) {
+	ids, err := d.inServiceInstanceIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []target
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > ec2MaxFilterValues {
+			n = ec2MaxFilterValues
+		}
+
+		filters := []*ec2.Filter{
+			{
+				Name:   aws.String("instance-id"),
+				Values: aws.StringSlice(ids[:n]),
+			},
+		}
+		ids = ids[n:]
+
+		instances, err := describeRunningInstances(ctx, d.ec2, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, instance := range instances {
+			targets = append(targets, instanceTargets(instance, d.address, d.deviceIndex)...)
+		}
+	}
+
+	return targets, nil
+}
+
+// inServiceInstanceIDs lists the ids of all instances of the auto scaling
+// groups that are in service, following pagination. Instances that are
+// pending, terminating or on standby are skipped
+func (d *asgDiscoverer) inServiceInstanceIDs(ctx context.Context) ([]string, error) {
+	input := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice(d.groups),
+		MaxRecords:            aws.Int64(asgMaxRecords),
+	}
+
+	found := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	var ids []string
+	for {
+		res, err := d.asg.DescribeAutoScalingGroupsWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("error describing auto scaling groups: %w", err)
+		}
+
+		for _, group := range res.AutoScalingGroups {
+			found[aws.StringValue(group.AutoScalingGroupName)] = true
+
+			for _, instance := range group.Instances {
+				id := aws.StringValue(instance.InstanceId)
+				if state := aws.StringValue(instance.LifecycleState); state != autoscaling.LifecycleStateInService {
+					log.Debug().Str("instance.id", id).Str("state", state).Msg("skipping instance as lifecycle state != InService")
+					continue
+				}
+
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+
+				ids = append(ids, id)
+			}
+		}
+
+		if aws.StringValue(res.NextToken) == "" {
+			break
+		}
+		input.NextToken = res.NextToken
+	}
+
+	for _, name := range d.groups {
+		if !found[name] {
+			log.Warn().Str("asg", name).Msg("auto scaling group not found")
+		}
+	}
+
+	return ids, nil
+}
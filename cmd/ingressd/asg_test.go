@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type mockASGDescriber struct {
+	describeFunc func(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+}
+
+func (m mockASGDescriber) DescribeAutoScalingGroupsWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	return m.describeFunc(input)
+}
+
+func TestASGDiscover(t *testing.T) {
+	t.Parallel()
+
+	// instances described by ec2, by id
+	instances := map[string]*ec2.Instance{
+		"i-1": {InstanceId: aws.String("i-1"), PrivateIpAddress: aws.String("10.0.0.1")},
+		"i-2": {InstanceId: aws.String("i-2"), PrivateIpAddress: aws.String("10.0.0.2")},
+		"i-6": {InstanceId: aws.String("i-6"), PrivateIpAddress: aws.String("10.0.0.6")},
+	}
+
+	client := mockEC2Describer{
+		describeFunc: func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			var matched []*ec2.Instance
+			for _, f := range input.Filters {
+				if aws.StringValue(f.Name) != "instance-id" {
+					continue
+				}
+				for _, id := range aws.StringValueSlice(f.Values) {
+					if instance, ok := instances[id]; ok {
+						matched = append(matched, instance)
+					}
+				}
+			}
+
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: matched}},
+			}, nil
+		},
+	}
+
+	pages := []*autoscaling.DescribeAutoScalingGroupsOutput{
+		{
+			AutoScalingGroups: []*autoscaling.Group{
+				{
+					AutoScalingGroupName: aws.String("haproxy-a"),
+					Instances: []*autoscaling.Instance{
+						{InstanceId: aws.String("i-1"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+						{InstanceId: aws.String("i-2"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+						{InstanceId: aws.String("i-3"), LifecycleState: aws.String(autoscaling.LifecycleStatePending)},
+					},
+				},
+			},
+			NextToken: aws.String("next"),
+		},
+		{
+			AutoScalingGroups: []*autoscaling.Group{
+				{
+					AutoScalingGroupName: aws.String("haproxy-b"),
+					Instances: []*autoscaling.Instance{
+						{InstanceId: aws.String("i-4"), LifecycleState: aws.String(autoscaling.LifecycleStateTerminatingWait)},
+						{InstanceId: aws.String("i-5"), LifecycleState: aws.String(autoscaling.LifecycleStateStandby)},
+						{InstanceId: aws.String("i-6"), LifecycleState: aws.String(autoscaling.LifecycleStateInService)},
+					},
+				},
+			},
+		},
+	}
+
+	var calls int
+	asg := mockASGDescriber{
+		describeFunc: func(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+			if calls > 0 && aws.StringValue(input.NextToken) != "next" {
+				return nil, fmt.Errorf("unexpected next token: %s", aws.StringValue(input.NextToken))
+			}
+			page := pages[calls]
+			calls++
+			return page, nil
+		},
+	}
+
+	d := newASGDiscoverer(asg, client, asgSourceConfig{
+		Names:   []string{"haproxy-a", "haproxy-b"},
+		Address: addressPrivate,
+	})
+
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	var ids []string
+	for _, target := range targets {
+		ids = append(ids, target.InstanceID)
+	}
+	if fmt.Sprint(ids) != "[i-1 i-2 i-6]" {
+		t.Errorf("expected instances: [i-1 i-2 i-6], got: %v", ids)
+	}
+	if len(targets) > 0 && targets[0].IP.String() != "10.0.0.1" {
+		t.Errorf("expected ip: 10.0.0.1, got: %s", targets[0].IP)
+	}
+}
+
+func TestASGDiscoverError(t *testing.T) {
+	t.Parallel()
+
+	asg := mockASGDescriber{
+		describeFunc: func(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+			return nil, fmt.Errorf("aws error")
+		},
+	}
+
+	d := newASGDiscoverer(asg, mockEC2Describer{}, asgSourceConfig{Names: []string{"haproxy"}, Address: addressPublic})
+
+	expected := "error describing auto scaling groups: aws error"
+	if _, err := d.Discover(context.Background()); err == nil || err.Error() != expected {
+		t.Errorf("expected error: '%s', got: '%v'", expected, err)
+	}
+}
+
+func TestASGDiscoverBatches(t *testing.T) {
+	t.Parallel()
+
+	group := &autoscaling.Group{AutoScalingGroupName: aws.String("haproxy")}
+	for i := 0; i < ec2MaxFilterValues+1; i++ {
+		group.Instances = append(group.Instances, &autoscaling.Instance{
+			InstanceId:     aws.String(fmt.Sprintf("i-%d", i)),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+		})
+	}
+
+	asg := mockASGDescriber{
+		describeFunc: func(*autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+			return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: []*autoscaling.Group{group}}, nil
+		},
+	}
+
+	var batches []int
+	client := mockEC2Describer{
+		describeFunc: func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			for _, f := range input.Filters {
+				if aws.StringValue(f.Name) == "instance-id" {
+					batches = append(batches, len(f.Values))
+				}
+			}
+			return &ec2.DescribeInstancesOutput{}, nil
+		},
+	}
+
+	if _, err := newASGDiscoverer(asg, client, asgSourceConfig{Names: []string{"haproxy"}, Address: addressPublic}).Discover(context.Background()); err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	if fmt.Sprint(batches) != fmt.Sprintf("[%d 1]", ec2MaxFilterValues) {
+		t.Errorf("expected batches: [%d 1], got: %v", ec2MaxFilterValues, batches)
+	}
+}
@@ -1,29 +1,192 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/route53"
-	"github.com/rs/zerolog/log"
+	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
+const (
+	// region serving the route53 api, a global service
+	defaultRoute53Region = "us-east-1"
+
+	// maximum number of resource records in a single Route53 change batch
+	route53MaxBatchRecords = 1000
+
+	// maximum combined length of resource record values in a single Route53 change batch
+	route53MaxBatchValueLength = 32000
+
+	// prefix of the hosted zone ids returned by Route53
+	route53HostedZonePrefix = "/hostedzone/"
+)
+
+// awsConfig defines the named credentials used by each aws service, along with
+// how requests to every service are retried and sent. Services without
+// credentials use the default credential chain
+type awsConfig struct {
+	EC2         string `yaml:"ec2" json:"ec2"`
+	AutoScaling string `yaml:"autoscaling" json:"autoscaling"`
+	SQS         string `yaml:"sqs" json:"sqs"`
+	Route53     string `yaml:"route53" json:"route53"`
+
+	// retry policy of failed or throttled requests
+	Retry retryConfig `yaml:"retry" json:"retry"`
+
+	// http transport of every request
+	HTTP awsHTTPConfig `yaml:"http" json:"http"`
+}
+
+// retryConfig defines how failed or throttled aws requests are retried, with
+// an exponential backoff between min_delay and max_delay
+type retryConfig struct {
+	// maximum number of retries of a single request, default: 3
+	MaxRetries *int `yaml:"max_retries" json:"max_retries"`
+
+	// shortest delay before a retry, default: 30ms
+	MinDelay duration `yaml:"min_delay" json:"min_delay"`
+
+	// longest delay before a retry, default: 20s
+	MaxDelay duration `yaml:"max_delay" json:"max_delay"`
+}
+
+// awsHTTPConfig defines the http client and transport shared by every aws client
+type awsHTTPConfig struct {
+	// timeout of a single request, including sqs long polling, default: 60s
+	Timeout duration `yaml:"timeout" json:"timeout"`
+
+	// maximum number of idle connections kept open to each aws endpoint, default: 10
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host" json:"max_idle_conns_per_host"`
+
+	// how long an idle connection is kept open, default: 90s
+	IdleConnTimeout duration `yaml:"idle_conn_timeout" json:"idle_conn_timeout"`
+}
+
+func (a awsConfig) validate() error {
+	if r := a.Retry; r.MaxRetries != nil && *r.MaxRetries < 0 {
+		return fmt.Errorf("retry.max_retries: must be positive")
+	}
+
+	if a.Retry.MinDelay.Duration < 0 {
+		return fmt.Errorf("retry.min_delay: must be positive")
+	}
+
+	if a.Retry.MaxDelay.Duration < a.Retry.MinDelay.Duration {
+		return fmt.Errorf("retry.max_delay: must not be less than min_delay")
+	}
+
+	// sqs messages are received with long polling
+	if t := a.HTTP.Timeout.Duration; t <= sqsWaitTimeSeconds*time.Second {
+		return fmt.Errorf("http.timeout: must be longer than the sqs wait time of %ds", sqsWaitTimeSeconds)
+	}
+
+	if a.HTTP.MaxIdleConnsPerHost < 1 {
+		return fmt.Errorf("http.max_idle_conns_per_host: must be positive")
+	}
+
+	if a.HTTP.IdleConnTimeout.Duration < 0 {
+		return fmt.Errorf("http.idle_conn_timeout: must be positive")
+	}
+
+	return nil
+}
+
+// retryer returns the sdk retryer of a retry policy. Throttled requests wait
+// at least as long as the sdk's default
+func (r retryConfig) retryer() client.DefaultRetryer {
+	minThrottleDelay := client.DefaultRetryerMinThrottleDelay
+	if r.MinDelay.Duration > minThrottleDelay {
+		minThrottleDelay = r.MinDelay.Duration
+	}
+	if r.MaxDelay.Duration < minThrottleDelay {
+		minThrottleDelay = r.MaxDelay.Duration
+	}
+
+	return client.DefaultRetryer{
+		NumMaxRetries:    aws.IntValue(r.MaxRetries),
+		MinRetryDelay:    r.MinDelay.Duration,
+		MinThrottleDelay: minThrottleDelay,
+		MaxRetryDelay:    r.MaxDelay.Duration,
+		MaxThrottleDelay: r.MaxDelay.Duration,
+	}
+}
+
+// client returns an http client with its own transport, so that connections
+// to aws are pooled and reused by every aws client
+func (h awsHTTPConfig) client() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = h.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = h.IdleConnTimeout.Duration
+
+	return &http.Client{
+		Timeout:   h.Timeout.Duration,
+		Transport: transport,
+	}
+}
+
+// newAWSSession creates the session shared by every aws client, with the
+// configured retry policy and http transport. Credentials are not resolved
+// until the first request, so missing credentials do not fail here
+func newAWSSession(cfg awsConfig) (*session.Session, error) {
+	c := request.WithRetryer(&aws.Config{
+		HTTPClient: cfg.HTTP.client(),
+	}, cfg.Retry.retryer())
+
+	sess, err := session.NewSession(c)
+	if err != nil {
+		return nil, fmt.Errorf("error creating aws session: %w", err)
+	}
+
+	return sess, nil
+}
+
 // ec2Describer implements functions for describing ec2 instance data
 type ec2Describer interface {
-	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	DescribeInstancesWithContext(aws.Context, *ec2.DescribeInstancesInput, ...request.Option) (*ec2.DescribeInstancesOutput, error)
+}
+
+// asgDescriber implements functions for describing auto scaling groups
+type asgDescriber interface {
+	DescribeAutoScalingGroupsWithContext(aws.Context, *autoscaling.DescribeAutoScalingGroupsInput, ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
+}
+
+// asgLifecycleCompleter implements functions for completing auto scaling lifecycle actions
+type asgLifecycleCompleter interface {
+	CompleteLifecycleActionWithContext(aws.Context, *autoscaling.CompleteLifecycleActionInput, ...request.Option) (*autoscaling.CompleteLifecycleActionOutput, error)
+}
+
+// asgReadWriter implements functions for reading and writing to auto scaling
+type asgReadWriter interface {
+	asgDescriber
+	asgLifecycleCompleter
+}
+
+// sqsReceiver implements functions for receiving and deleting sqs messages
+type sqsReceiver interface {
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error)
 }
 
 // route53ReadWriter implements functions for reading and writing to route53
 type route53ReadWriter interface {
-	ChangeResourceRecordSets(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error)
-	ListHostedZones(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error)
+	ChangeResourceRecordSetsWithContext(aws.Context, *route53.ChangeResourceRecordSetsInput, ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error)
+	ListHostedZonesWithContext(aws.Context, *route53.ListHostedZonesInput, ...request.Option) (*route53.ListHostedZonesOutput, error)
+	ListResourceRecordSetsWithContext(aws.Context, *route53.ListResourceRecordSetsInput, ...request.Option) (*route53.ListResourceRecordSetsOutput, error)
+	GetHostedZoneWithContext(aws.Context, *route53.GetHostedZoneInput, ...request.Option) (*route53.GetHostedZoneOutput, error)
 }
 
-// service manager for aws ec2 and route53
+// service manager for aws ec2, auto scaling, sqs and route53
 type awsManager struct {
 	// aws region of the below services
 	region string
@@ -31,101 +194,231 @@ type awsManager struct {
 	// aws service for interacting with the ec2 api
 	ec2 ec2Describer
 
+	// aws service for interacting with the auto scaling api
+	autoscaling asgReadWriter
+
+	// aws service for interacting with the sqs api
+	sqs sqsReceiver
+
 	// aws service for interacting with the route53 api
 	route53 route53ReadWriter
-}
 
-// create new aws services with a reusable configured session
-func newAWSManager(region string) awsManager {
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	}))
+	// optional cache of route53 hosted zones, zones are listed on every lookup if nil
+	zones *zoneCache
+}
 
+// create new aws services of a region from a shared session, each using the
+// given credentials
+func newAWSManager(sess *session.Session, region string, creds serviceCredentials) awsManager {
 	return awsManager{
-		region:  region,
-		ec2:     ec2.New(sess),
-		route53: route53.New(sess),
-	}
-}
-
-// getTaggedEC2PublicIPAddrs queries ec2 for all instances of a given name,
-// returning their public ip addr if configured
-func (mgr awsManager) getTaggedEC2PublicIPAddrs(key, value string) ([]net.IP, error) {
-	input := &ec2.DescribeInstancesInput{
-		Filters: []*ec2.Filter{
-			{
-				Name: aws.String(fmt.Sprintf("tag:%s", key)),
-				Values: []*string{
-					aws.String(value),
-				},
-			},
-		},
+		region:      region,
+		ec2:         ec2.New(sess, &aws.Config{Region: aws.String(region), Credentials: creds.ec2}),
+		autoscaling: autoscaling.New(sess, &aws.Config{Region: aws.String(region), Credentials: creds.autoscaling}),
+		sqs:         sqs.New(sess, &aws.Config{Region: aws.String(region), Credentials: creds.sqs}),
+		route53:     route53.New(sess, &aws.Config{Region: aws.String(region), Credentials: creds.route53}),
 	}
+}
+
+// listRoute53HostedZones lists every Route53 Hosted Zone, following pagination
+func (mgr awsManager) listRoute53HostedZones(ctx context.Context) ([]*route53.HostedZone, error) {
+	var zones []*route53.HostedZone
+
+	input := &route53.ListHostedZonesInput{}
+	for {
+		res, err := mgr.route53.ListHostedZonesWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("error listing hosted zones: %w", err)
+		}
+
+		zones = append(zones, res.HostedZones...)
 
-	res, err := mgr.ec2.DescribeInstances(input)
+		if !aws.BoolValue(res.IsTruncated) {
+			return zones, nil
+		}
+		input.Marker = res.NextMarker
+	}
+}
+
+// getRoute53HostedZoneID attempts to match a given host addr to a Route53 Hosted Zone,
+// optionally filtered by zone type and, for private zones, vpc association.
+// If a match is found, the zone id is returned
+func (mgr awsManager) getRoute53HostedZoneID(ctx context.Context, host, zoneType, vpcID string) (string, error) {
+	var zones []*route53.HostedZone
+	var err error
+	if mgr.zones != nil {
+		zones, err = mgr.zones.get(ctx)
+	} else {
+		zones, err = mgr.listRoute53HostedZones(ctx)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error describing instances: %w", err)
+		return "", err
 	}
 
-	var ips []net.IP
-	for _, reservation := range res.Reservations {
-		for _, instance := range reservation.Instances {
-			// check instance is running
-			if aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
-				log.Info().Str("instance.id", aws.StringValue(instance.InstanceId)).Msg("skipping instance as state != running")
+	// the vpc associations of private zones are only required when filtering by vpc
+	var vpcs map[string][]string
+	if vpcID != "" {
+		vpcs = make(map[string][]string)
+		for _, zone := range zones {
+			if !isPrivateZone(zone) || !hostInZone(host, aws.StringValue(zone.Name)) {
 				continue
 			}
 
-			// check public ip addr is valid
-			if publicIP := net.ParseIP(aws.StringValue(instance.PublicIpAddress)); publicIP != nil {
-				ips = append(ips, publicIP)
+			id := aws.StringValue(zone.Id)
+			if mgr.zones != nil {
+				vpcs[id], err = mgr.zones.getVPCs(ctx, id, mgr.getRoute53HostedZoneVPCs)
+			} else {
+				vpcs[id], err = mgr.getRoute53HostedZoneVPCs(ctx, id)
+			}
+			if err != nil {
+				return "", err
 			}
 		}
 	}
 
-	return ips, nil
+	zone, err := matchRoute53HostedZone(host, zones, zoneType, vpcID, vpcs)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(zone.Id), nil
 }
 
-// getRoute53HostedZoneID attempts to match a given host addr to a Route53 Hosted Zone.
-// If a match is found, the zone id is returned
-func (mgr awsManager) getRoute53HostedZoneID(host string) (string, error) {
-	zones, err := mgr.route53.ListHostedZones(&route53.ListHostedZonesInput{})
+// getRoute53HostedZoneVPCs returns the ids of the vpcs associated with a private hosted zone
+func (mgr awsManager) getRoute53HostedZoneVPCs(ctx context.Context, zoneID string) ([]string, error) {
+	res, err := mgr.route53.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{
+		Id: aws.String(zoneID),
+	})
 	if err != nil {
-		return "", fmt.Errorf("error listing hosted zones: %w", err)
+		return nil, fmt.Errorf("error getting hosted zone: %w", err)
 	}
 
-	var found route53.HostedZone
+	ids := make([]string, 0, len(res.VPCs))
+	for _, vpc := range res.VPCs {
+		ids = append(ids, aws.StringValue(vpc.VPCId))
+	}
+
+	return ids, nil
+}
+
+// getRoute53RecordSet reads the record set of a given host, type and set
+// identifier from a hosted zone. If no record set exists, nil is returned
+func (mgr awsManager) getRoute53RecordSet(ctx context.Context, zoneID, host, rrType, setID string) (*route53.ResourceRecordSet, error) {
+	// record sets are listed in order, so starting at the given name, type and
+	// set identifier will return the matching record set first if it exists
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: aws.String(host),
+		StartRecordType: aws.String(rrType),
+		MaxItems:        aws.String("1"),
+	}
+	if setID != "" {
+		input.StartRecordIdentifier = aws.String(setID)
+	}
 
-	for _, zone := range zones.HostedZones {
-		// aws will return will return the fully qualified dns record,
-		// so we need to strip the last '.'
-		name := strings.TrimSuffix(aws.StringValue(zone.Name), ".")
+	res, err := mgr.route53.ListResourceRecordSetsWithContext(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("error listing record sets: %w", err)
+	}
 
-		// if the host addr has the suffix of zone name, we have a potential match.
-		// however, we should also check the length of the zone in case of duplicate matches,
-		// for example: a host with suffix 'ingressd.syscll.org' would match both 'ingressd.syscll.org'
-		// and 'syscll.org'.
-		// in this case, we should prefer the most precise match: 'syscll.org'
-		if strings.HasSuffix(host, name) && len(name) > len(aws.StringValue(found.Name)) {
-			found = *zone
+	for _, rrs := range res.ResourceRecordSets {
+		if normalizeHost(aws.StringValue(rrs.Name)) == normalizeHost(host) && aws.StringValue(rrs.Type) == rrType && aws.StringValue(rrs.SetIdentifier) == setID {
+			return rrs, nil
 		}
 	}
 
-	id := aws.StringValue(found.Id)
-	if id == "" {
-		return "", fmt.Errorf("no zone id found for: %s", host)
+	return nil, nil
+}
+
+// getConflictingRoute53RecordSet returns the record set of a given host and type
+// that route53 does not allow next to a record set with the given set
+// identifier: a simple record set next to a routed one, or any routed record
+// set next to a simple one. If there is none, nil is returned
+func (mgr awsManager) getConflictingRoute53RecordSet(ctx context.Context, zoneID, host, rrType, setID string) (*route53.ResourceRecordSet, error) {
+	// simple and routed record sets of the same host and type cannot coexist,
+	// so the first record set listed decides whether there is a conflict
+	res, err := mgr.route53.ListResourceRecordSetsWithContext(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: aws.String(host),
+		StartRecordType: aws.String(rrType),
+		MaxItems:        aws.String("1"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing record sets: %w", err)
 	}
 
-	return id, nil
+	for _, rrs := range res.ResourceRecordSets {
+		if normalizeHost(aws.StringValue(rrs.Name)) == normalizeHost(host) && aws.StringValue(rrs.Type) == rrType && (aws.StringValue(rrs.SetIdentifier) == "") != (setID == "") {
+			return rrs, nil
+		}
+	}
+
+	return nil, nil
 }
 
-// ensureRoute53RecordSet attempts to upsert a Route53 A record for a given
-// host and set of ip addrs
-func (mgr awsManager) ensureRoute53RecordSet(host string, ips []net.IP) error {
-	if len(ips) == 0 {
-		return fmt.Errorf("no ips provided")
+// planRoute53RecordSet compares the current Route53 record set of a given host,
+// type and routing against a set of ip addrs and the configured ttl, without
+// performing any changes. If no hosted zone id is configured, it is looked up
+// from the host
+func (mgr awsManager) planRoute53RecordSet(ctx context.Context, host, rrType string, routing recordRouting, dns dnsConfig, ips []net.IP) (recordPlan, error) {
+	// attempt to automatically get the hosted zone id for the given host
+	zoneID := dns.ZoneID
+	if zoneID == "" {
+		var err error
+		if zoneID, err = mgr.getRoute53HostedZoneID(ctx, host, dns.ZoneType, dns.VPCID); err != nil {
+			return newRecordPlan(host, rrType, routing, aws.Int64Value(dns.TTL)), fmt.Errorf("error getting route53 hosted zone: %w", err)
+		}
 	}
 
+	// pinned and looked up ids of the same zone must match, so that changes
+	// are batched by zone
+	zoneID = normalizeZoneID(zoneID)
+
+	current, err := mgr.getRoute53RecordSet(ctx, zoneID, host, rrType, routing.SetIdentifier)
+	if err != nil {
+		plan := newRecordPlan(host, rrType, routing, aws.Int64Value(dns.TTL))
+		plan.ZoneID = zoneID
+		return plan, fmt.Errorf("error getting route53 record set: %w", err)
+	}
+
+	plan := diffRecordSet(host, current, routing, ips, aws.Int64Value(dns.TTL))
+	plan.Type = rrType
+	plan.SetIdentifier = routing.SetIdentifier
+	plan.ZoneID = zoneID
+
+	// route53 rejects creating a record set next to one with another routing
+	// policy, which would fail the change batch of the whole zone
+	if current == nil {
+		conflict, err := mgr.getConflictingRoute53RecordSet(ctx, zoneID, host, rrType, routing.SetIdentifier)
+		if err != nil {
+			return plan, fmt.Errorf("error getting route53 record set: %w", err)
+		}
+		if conflict != nil {
+			existing := routingOf(conflict)
+			if existing.SetIdentifier != "" {
+				return plan, fmt.Errorf("existing record set %s with %s routing conflicts, delete it to use %s routing", existing.SetIdentifier, existing.describe(), routing.describe())
+			}
+			return plan, fmt.Errorf("existing record set with %s routing conflicts, delete it to use %s routing", existing.describe(), routing.describe())
+		}
+	}
+
+	return plan, nil
+}
+
+// normalizeHost returns a host name in lower case without a trailing '.', as
+// route53 returns fully qualified names
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// normalizeZoneID returns a hosted zone id without the /hostedzone/ prefix
+// returned by route53
+func normalizeZoneID(id string) string {
+	return strings.TrimPrefix(id, route53HostedZonePrefix)
+}
+
+// newRoute53Change creates an upsert change of a Route53 A or AAAA record set,
+// with the given routing, for a given host and set of ip addrs
+func newRoute53Change(host, rrType string, routing recordRouting, ips []net.IP, ttl int64) *route53.Change {
 	// loop through each of the given ip addrs and create a ResourceRecord for each
 	var records []*route53.ResourceRecord
 	for _, ip := range ips {
@@ -134,33 +427,92 @@ func (mgr awsManager) ensureRoute53RecordSet(host string, ips []net.IP) error {
 		})
 	}
 
-	// create change record of type A with a 60s TTL
-	change := &route53.Change{
-		Action: aws.String(route53.ChangeActionUpsert),
-		ResourceRecordSet: &route53.ResourceRecordSet{
-			Name:            aws.String(host),
-			ResourceRecords: records,
-			TTL:             aws.Int64(60),
-			Type:            aws.String(route53.RRTypeA),
-		},
+	// create change record of the given type with the given TTL
+	rrs := &route53.ResourceRecordSet{
+		Name:            aws.String(host),
+		ResourceRecords: records,
+		TTL:             aws.Int64(ttl),
+		Type:            aws.String(rrType),
 	}
+	routing.apply(rrs)
 
-	// attempt to automatically get the hosted zone id for the given host
-	zoneID, err := mgr.getRoute53HostedZoneID(host)
-	if err != nil {
-		return fmt.Errorf("error getting route53 hosted zone: %w", err)
+	return &route53.Change{
+		Action:            aws.String(route53.ChangeActionUpsert),
+		ResourceRecordSet: rrs,
 	}
+}
 
-	input := &route53.ChangeResourceRecordSetsInput{
-		ChangeBatch: &route53.ChangeBatch{
-			Changes: []*route53.Change{change},
-		},
-		HostedZoneId: aws.String(zoneID),
+// ipRRType returns the record type an ip addr is published as, A or AAAA
+func ipRRType(ip net.IP) string {
+	if ip.To4() == nil {
+		return route53.RRTypeAaaa
 	}
 
-	if _, err := mgr.route53.ChangeResourceRecordSets(input); err != nil {
-		return fmt.Errorf("error performing change to record set: %w", err)
+	return route53.RRTypeA
+}
+
+// splitRoute53Changes splits a set of changes into as few batches as the Route53
+// change batch limits allow, preserving order. A single change that exceeds the
+// limits on its own is placed in its own batch
+func splitRoute53Changes(changes []*route53.Change) [][]*route53.Change {
+	var batches [][]*route53.Change
+	var batch []*route53.Change
+	var records, length int
+
+	for _, change := range changes {
+		// upserts are counted twice, as a delete and a create
+		weight := 1
+		if aws.StringValue(change.Action) == route53.ChangeActionUpsert {
+			weight = 2
+		}
+
+		r, l := 0, 0
+		for _, rr := range change.ResourceRecordSet.ResourceRecords {
+			r += weight
+			l += weight * len(aws.StringValue(rr.Value))
+		}
+
+		if len(batch) > 0 && (records+r > route53MaxBatchRecords || length+l > route53MaxBatchValueLength) {
+			batches = append(batches, batch)
+			batch, records, length = nil, 0, 0
+		}
+
+		batch = append(batch, change)
+		records += r
+		length += l
 	}
 
-	return nil
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// ensureRoute53Changes attempts to apply a set of changes to a given hosted zone,
+// using a single atomic change batch unless Route53 limits require it to be split.
+// The returned errors match the given changes by index, so that a failed batch is
+// reported against each of its changes
+func (mgr awsManager) ensureRoute53Changes(ctx context.Context, zoneID string, changes []*route53.Change) []error {
+	errs := make([]error, 0, len(changes))
+
+	for _, batch := range splitRoute53Changes(changes) {
+		input := &route53.ChangeResourceRecordSetsInput{
+			ChangeBatch: &route53.ChangeBatch{
+				Changes: batch,
+			},
+			HostedZoneId: aws.String(zoneID),
+		}
+
+		_, err := mgr.route53.ChangeResourceRecordSetsWithContext(ctx, input)
+		if err != nil {
+			err = fmt.Errorf("error performing change to record sets: %w", err)
+		}
+
+		for range batch {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
 }
@@ -1,29 +1,42 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/route53"
 )
 
 type mockRoute53ReadWriter struct {
 	changeFunc func(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error)
 	listFunc   func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error)
+	recordFunc func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error)
+	getFunc    func(*route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error)
 	err        error
 }
 
-func (m mockRoute53ReadWriter) ChangeResourceRecordSets(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+func (m mockRoute53ReadWriter) ChangeResourceRecordSetsWithContext(_ aws.Context, input *route53.ChangeResourceRecordSetsInput, _ ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error) {
 	return m.changeFunc(input)
 }
 
-func (m mockRoute53ReadWriter) ListHostedZones(input *route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+func (m mockRoute53ReadWriter) ListHostedZonesWithContext(_ aws.Context, input *route53.ListHostedZonesInput, _ ...request.Option) (*route53.ListHostedZonesOutput, error) {
 	return m.listFunc(input)
 }
 
+func (m mockRoute53ReadWriter) ListResourceRecordSetsWithContext(_ aws.Context, input *route53.ListResourceRecordSetsInput, _ ...request.Option) (*route53.ListResourceRecordSetsOutput, error) {
+	return m.recordFunc(input)
+}
+
+func (m mockRoute53ReadWriter) GetHostedZoneWithContext(_ aws.Context, input *route53.GetHostedZoneInput, _ ...request.Option) (*route53.GetHostedZoneOutput, error) {
+	return m.getFunc(input)
+}
+
 func TestGetRoute53HostedZoneID(t *testing.T) {
 	t.Parallel()
 
@@ -73,7 +86,7 @@ func TestGetRoute53HostedZoneID(t *testing.T) {
 				route53: test,
 			}
 
-			id, err := mgr.getRoute53HostedZoneID("syscll.org")
+			id, err := mgr.getRoute53HostedZoneID(context.Background(), "syscll.org", "", "")
 			if test.err != nil && err.Error() != test.err.Error() {
 				t.Errorf("expected error: '%v', got: '%v'", test.err, err)
 			}
@@ -89,62 +102,151 @@ func TestGetRoute53HostedZoneID(t *testing.T) {
 	}
 }
 
-type mockEC2Describer struct {
-	describeFunc func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
-	err          error
+func TestListRoute53HostedZones(t *testing.T) {
+	t.Parallel()
+
+	// each page returns a single zone, indexed by marker
+	pages := map[string]*route53.ListHostedZonesOutput{
+		"": {
+			HostedZones: []*route53.HostedZone{{Id: aws.String("zone-1"), Name: aws.String("syscll.org.")}},
+			IsTruncated: aws.Bool(true),
+			NextMarker:  aws.String("page-2"),
+		},
+		"page-2": {
+			HostedZones: []*route53.HostedZone{{Id: aws.String("zone-2"), Name: aws.String("example.org.")}},
+			IsTruncated: aws.Bool(true),
+			NextMarker:  aws.String("page-3"),
+		},
+		"page-3": {
+			HostedZones: []*route53.HostedZone{{Id: aws.String("zone-3"), Name: aws.String("ingressd.org.")}},
+			IsTruncated: aws.Bool(false),
+		},
+	}
+
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			listFunc: func(input *route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+				page, ok := pages[aws.StringValue(input.Marker)]
+				if !ok {
+					return nil, fmt.Errorf("unexpected marker: %s", aws.StringValue(input.Marker))
+				}
+				return page, nil
+			},
+		},
+	}
+
+	zones, err := mgr.listRoute53HostedZones(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if len(zones) != 3 {
+		t.Fatalf("expected 3 zones, got: %d", len(zones))
+	}
+
+	// a zone on the last page must be matched
+	id, err := mgr.getRoute53HostedZoneID(context.Background(), "ingressd.org", "", "")
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if id != "zone-3" {
+		t.Errorf("expected zone id: 'zone-3', got: '%s'", id)
+	}
 }
 
-func (m mockEC2Describer) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-	return m.describeFunc(input)
+func TestGetRoute53HostedZoneIDVPC(t *testing.T) {
+	t.Parallel()
+
+	var gets int
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+				return &route53.ListHostedZonesOutput{
+					HostedZones: []*route53.HostedZone{
+						{Id: aws.String("public"), Name: aws.String("syscll.org."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(false)}},
+						{Id: aws.String("private-a"), Name: aws.String("syscll.org."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+						{Id: aws.String("private-b"), Name: aws.String("syscll.org."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+						{Id: aws.String("private-other"), Name: aws.String("example.org."), Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)}},
+					},
+				}, nil
+			},
+			getFunc: func(input *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+				gets++
+				vpcs := map[string]string{"private-a": "vpc-a", "private-b": "vpc-b"}
+				vpc, ok := vpcs[aws.StringValue(input.Id)]
+				if !ok {
+					return nil, fmt.Errorf("unexpected zone id: %s", aws.StringValue(input.Id))
+				}
+				return &route53.GetHostedZoneOutput{
+					VPCs: []*route53.VPC{{VPCId: aws.String(vpc)}},
+				}, nil
+			},
+		},
+	}
+
+	id, err := mgr.getRoute53HostedZoneID(context.Background(), "ingress.syscll.org", zoneTypePrivate, "vpc-b")
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if id != "private-b" {
+		t.Errorf("expected zone id: 'private-b', got: '%s'", id)
+	}
+
+	// only private zones matching the host should be described
+	if gets != 2 {
+		t.Errorf("expected 2 hosted zones to be described, got: %d", gets)
+	}
 }
 
-func TestGetEC2PublicIPAddrs(t *testing.T) {
+func TestPlanRoute53RecordSet(t *testing.T) {
 	t.Parallel()
 
-	testTable := make(map[string]mockEC2Describer)
+	testTable := make(map[string]mockRoute53ReadWriter)
 
-	testTable["TestListHostedZonesError"] = mockEC2Describer{
-		describeFunc: func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-			return nil, fmt.Errorf("aws error")
+	testTable["TestHostedZoneIDError"] = mockRoute53ReadWriter{
+		listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+			return nil, fmt.Errorf("route53 error")
 		},
-		err: fmt.Errorf("error describing instances: aws error"),
+		err: fmt.Errorf("error getting route53 hosted zone: error listing hosted zones: route53 error"),
 	}
 
-	testTable["TestSuccess"] = mockEC2Describer{
-		describeFunc: func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
-			return &ec2.DescribeInstancesOutput{
-				Reservations: []*ec2.Reservation{
+	testTable["TestRecordSetError"] = mockRoute53ReadWriter{
+		listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+			return &route53.ListHostedZonesOutput{
+				HostedZones: []*route53.HostedZone{
+					{
+						Id:   aws.String("zone-1"),
+						Name: aws.String("syscll.org."),
+					},
+				},
+			}, nil
+		},
+		recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+			return nil, fmt.Errorf("route53 error")
+		},
+		err: fmt.Errorf("error getting route53 record set: error listing record sets: route53 error"),
+	}
+
+	testTable["TestSuccess"] = mockRoute53ReadWriter{
+		listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+			return &route53.ListHostedZonesOutput{
+				HostedZones: []*route53.HostedZone{
+					{
+						Id:   aws.String("zone-1"),
+						Name: aws.String("syscll.org."),
+					},
+				},
+			}, nil
+		},
+		recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+			return &route53.ListResourceRecordSetsOutput{
+				ResourceRecordSets: []*route53.ResourceRecordSet{
 					{
-						Instances: []*ec2.Instance{
-							{
-								InstanceId:      aws.String("1"),
-								PublicIpAddress: aws.String("192.168.0.1"),
-								State: &ec2.InstanceState{
-									Name: aws.String(ec2.InstanceStateNameRunning),
-								},
-							},
-							{
-								InstanceId:      aws.String("2"),
-								PublicIpAddress: aws.String("192.168.0.2"),
-								State: &ec2.InstanceState{
-									Name: aws.String(ec2.InstanceStateNameRunning),
-								},
-							},
-							{
-								InstanceId:      aws.String("3"),
-								PublicIpAddress: aws.String("192.168.0.3"),
-								State: &ec2.InstanceState{
-									Name: aws.String(ec2.InstanceStateNameTerminated),
-								},
-							},
-							{
-								InstanceId:      aws.String("4"),
-								PublicIpAddress: aws.String("192.168.0.4"),
-								State: &ec2.InstanceState{
-									Name: aws.String(ec2.InstanceStateNameStopping),
-								},
-							},
+						Name: aws.String("syscll.org."),
+						ResourceRecords: []*route53.ResourceRecord{
+							{Value: aws.String("192.168.0.1")},
 						},
+						TTL:  aws.Int64(defaultRecordTTL),
+						Type: aws.String(route53.RRTypeA),
 					},
 				},
 			}, nil
@@ -153,12 +255,13 @@ func TestGetEC2PublicIPAddrs(t *testing.T) {
 	}
 
 	for name, test := range testTable {
+		test := test
 		t.Run(name, func(t *testing.T) {
 			mgr := awsManager{
-				ec2: test,
+				route53: test,
 			}
 
-			ips, err := mgr.getTaggedEC2PublicIPAddrs("key", "value")
+			plan, err := mgr.planRoute53RecordSet(context.Background(), "syscll.org", route53.RRTypeA, recordRouting{}, dnsConfig{TTL: aws.Int64(defaultRecordTTL)}, []net.IP{net.ParseIP("192.168.0.1")})
 			if test.err != nil && err.Error() != test.err.Error() {
 				t.Errorf("expected error: '%v', got: '%v'", test.err, err)
 			}
@@ -166,56 +269,267 @@ func TestGetEC2PublicIPAddrs(t *testing.T) {
 				if err != nil {
 					t.Errorf("expected error: nil, got: %v", err)
 				}
-
-				for _, ip := range ips {
-					if ip.String() != "192.168.0.1" && ip.String() != "192.168.0.2" {
-						t.Fatalf("incorrect list of ip addrs: %s", ips)
-					}
+				if plan.ZoneID != "zone-1" {
+					t.Errorf("expected zone id: 'zone-1', got: '%s'", plan.ZoneID)
+				}
+				if plan.Changed {
+					t.Errorf("expected unchanged plan, got: %+v", plan)
 				}
 			}
 		})
 	}
 }
 
-func TestEnsureRoute53RecordSet(t *testing.T) {
+func TestPlanRoute53RecordSetPinnedZone(t *testing.T) {
 	t.Parallel()
 
-	testTable := make(map[string]mockRoute53ReadWriter)
+	// a pinned zone id must not list hosted zones
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			recordFunc: func(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+				if aws.StringValue(input.HostedZoneId) != "zone-pinned" {
+					return nil, fmt.Errorf("unexpected zone id: %s", aws.StringValue(input.HostedZoneId))
+				}
+				return &route53.ListResourceRecordSetsOutput{}, nil
+			},
+		},
+	}
 
-	testTable["TestHostedZoneIDError"] = mockRoute53ReadWriter{
-		listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
-			return nil, fmt.Errorf("route53 error")
+	plan, err := mgr.planRoute53RecordSet(context.Background(), "syscll.org", route53.RRTypeA, recordRouting{}, dnsConfig{TTL: aws.Int64(defaultRecordTTL), ZoneID: "zone-pinned"}, []net.IP{net.ParseIP("192.168.0.1")})
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if plan.ZoneID != "zone-pinned" || !plan.Changed {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestPlanRoute53RecordSetZoneID(t *testing.T) {
+	t.Parallel()
+
+	// looked up zone ids are prefixed, pinned zone ids may not be
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+				return &route53.ListHostedZonesOutput{
+					HostedZones: []*route53.HostedZone{{Id: aws.String("/hostedzone/Z1"), Name: aws.String("syscll.org.")}},
+				}, nil
+			},
+			recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+				return &route53.ListResourceRecordSetsOutput{}, nil
+			},
 		},
-		err: fmt.Errorf("error getting route53 hosted zone: error listing hosted zones: route53 error"),
 	}
 
+	ips := []net.IP{net.ParseIP("192.168.0.1")}
+	for _, zoneID := range []string{"", "Z1", "/hostedzone/Z1"} {
+		plan, err := mgr.planRoute53RecordSet(context.Background(), "syscll.org", route53.RRTypeA, recordRouting{}, dnsConfig{TTL: aws.Int64(defaultRecordTTL), ZoneID: zoneID}, ips)
+		if err != nil {
+			t.Fatalf("expected error: nil, got: %v", err)
+		}
+		if plan.ZoneID != "Z1" {
+			t.Errorf("zone id %q: expected zone id: 'Z1', got: '%s'", zoneID, plan.ZoneID)
+		}
+	}
+}
+
+func TestEnsureRoute53Changes(t *testing.T) {
+	t.Parallel()
+
+	testTable := make(map[string]mockRoute53ReadWriter)
+
 	testTable["TestChangeRecordSetError"] = mockRoute53ReadWriter{
 		changeFunc: func(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
 			return nil, fmt.Errorf("route53 error")
 		},
-		listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
-			return &route53.ListHostedZonesOutput{
-				HostedZones: []*route53.HostedZone{
+		err: fmt.Errorf("error performing change to record sets: route53 error"),
+	}
+
+	testTable["TestSuccess"] = mockRoute53ReadWriter{
+		changeFunc: func(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+			if aws.StringValue(input.HostedZoneId) != "zone-1" {
+				return nil, fmt.Errorf("unexpected zone id: %s", aws.StringValue(input.HostedZoneId))
+			}
+			if len(input.ChangeBatch.Changes) != 2 {
+				return nil, fmt.Errorf("expected a single batch of 2 changes, got: %d", len(input.ChangeBatch.Changes))
+			}
+			return nil, nil
+		},
+		err: nil,
+	}
+
+	for name, test := range testTable {
+		t.Run(name, func(t *testing.T) {
+			mgr := awsManager{
+				route53: test,
+			}
+
+			ips := []net.IP{net.ParseIP("192.168.0.1")}
+			changes := []*route53.Change{
+				newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL),
+				newRoute53Change("ingress.syscll.org", route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL),
+			}
+
+			errs := mgr.ensureRoute53Changes(context.Background(), "zone-1", changes)
+			if len(errs) != len(changes) {
+				t.Fatalf("expected %d errors, got: %d", len(changes), len(errs))
+			}
+
+			for _, err := range errs {
+				if test.err != nil && err.Error() != test.err.Error() {
+					t.Errorf("expected error: '%v', got: '%v'", test.err, err)
+				}
+				if test.err == nil && err != nil {
+					t.Errorf("expected error: nil, got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestEnsureRoute53ChangesPartialFailure(t *testing.T) {
+	t.Parallel()
+
+	// fail only the second batch
+	var calls int
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			changeFunc: func(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+				calls++
+				if calls == 2 {
+					return nil, fmt.Errorf("route53 error")
+				}
+				return nil, nil
+			},
+		},
+	}
+
+	// each change holds 300 ip addrs, counted twice as an upsert, so only one
+	// change fits in each batch
+	var ips []net.IP
+	for i := 0; i < 300; i++ {
+		ips = append(ips, net.IPv4(10, 0, byte(i/256), byte(i%256)))
+	}
+	changes := []*route53.Change{
+		newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL),
+		newRoute53Change("ingress.syscll.org", route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL),
+	}
+
+	errs := mgr.ensureRoute53Changes(context.Background(), "zone-1", changes)
+	if calls != 2 {
+		t.Fatalf("expected 2 batches, got: %d", calls)
+	}
+	if errs[0] != nil {
+		t.Errorf("expected error: nil, got: %v", errs[0])
+	}
+	if errs[1] == nil {
+		t.Errorf("expected error, got: nil")
+	}
+}
+
+func TestSplitRoute53ChangesValueLength(t *testing.T) {
+	t.Parallel()
+
+	// 250 ip addrs of 39 characters, counted twice as an upsert, is within the
+	// record limit but 2 changes exceed the combined value length limit
+	var ips []net.IP
+	for i := 0; i < 250; i++ {
+		ips = append(ips, net.ParseIP(fmt.Sprintf("2001:db80:1234:5678:9abc:def0:%04x:%04x", 0x1000+i, 0x1000+i)))
+	}
+	changes := []*route53.Change{
+		newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL),
+		newRoute53Change("ingress.syscll.org", route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL),
+	}
+
+	if batches := splitRoute53Changes(changes); len(batches) != 2 {
+		t.Errorf("expected 2 batches, got: %d", len(batches))
+	}
+}
+
+func TestSplitRoute53Changes(t *testing.T) {
+	t.Parallel()
+
+	ipsN := func(n int) []net.IP {
+		var ips []net.IP
+		for i := 0; i < n; i++ {
+			ips = append(ips, net.IPv4(10, 0, byte(i/256), byte(i%256)))
+		}
+		return ips
+	}
+
+	testTable := map[string]struct {
+		sizes   []int
+		batches []int
+	}{
+		"TestSingleBatch": {
+			sizes:   []int{3, 3, 3},
+			batches: []int{3},
+		},
+		"TestRecordLimit": {
+			// 2 * (250 + 250) == 1000 records fit, the next does not
+			sizes:   []int{250, 250, 1},
+			batches: []int{2, 1},
+		},
+		"TestOversizedChange": {
+			sizes:   []int{1, 600, 1},
+			batches: []int{1, 1, 1},
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var changes []*route53.Change
+			for _, n := range test.sizes {
+				changes = append(changes, newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{}, ipsN(n), defaultRecordTTL))
+			}
+
+			batches := splitRoute53Changes(changes)
+			if len(batches) != len(test.batches) {
+				t.Fatalf("expected %d batches, got: %d", len(test.batches), len(batches))
+			}
+			for i, batch := range batches {
+				if len(batch) != test.batches[i] {
+					t.Errorf("batch %d: expected %d changes, got: %d", i, test.batches[i], len(batch))
+				}
+			}
+		})
+	}
+}
+
+func TestGetRoute53RecordSet(t *testing.T) {
+	t.Parallel()
+
+	testTable := make(map[string]mockRoute53ReadWriter)
+
+	testTable["TestListRecordSetsError"] = mockRoute53ReadWriter{
+		recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+			return nil, fmt.Errorf("route53 error")
+		},
+		err: fmt.Errorf("error listing record sets: route53 error"),
+	}
+
+	testTable["TestNotFound"] = mockRoute53ReadWriter{
+		recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+			return &route53.ListResourceRecordSetsOutput{
+				ResourceRecordSets: []*route53.ResourceRecordSet{
 					{
-						Id:   aws.String("zone-1"),
-						Name: aws.String("syscll.org."),
+						Name: aws.String("www.syscll.org."),
+						Type: aws.String(route53.RRTypeA),
 					},
 				},
 			}, nil
 		},
-		err: fmt.Errorf("error performing change to record set: route53 error"),
+		err: nil,
 	}
 
 	testTable["TestSuccess"] = mockRoute53ReadWriter{
-		changeFunc: func(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
-			return nil, nil
-		},
-		listFunc: func(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
-			return &route53.ListHostedZonesOutput{
-				HostedZones: []*route53.HostedZone{
+		recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+			return &route53.ListResourceRecordSetsOutput{
+				ResourceRecordSets: []*route53.ResourceRecordSet{
 					{
-						Id:   aws.String("zone-1"),
-						Name: aws.String("syscll.org"),
+						Name: aws.String("Syscll.org."),
+						Type: aws.String(route53.RRTypeA),
 					},
 				},
 			}, nil
@@ -224,14 +538,13 @@ func TestEnsureRoute53RecordSet(t *testing.T) {
 	}
 
 	for name, test := range testTable {
+		name, test := name, test
 		t.Run(name, func(t *testing.T) {
 			mgr := awsManager{
 				route53: test,
 			}
 
-			ips := []net.IP{net.IP("192.168.0.1")}
-
-			err := mgr.ensureRoute53RecordSet("syscll.org", ips)
+			rrs, err := mgr.getRoute53RecordSet(context.Background(), "zone-1", "syscll.org", route53.RRTypeA, "")
 			if test.err != nil && err.Error() != test.err.Error() {
 				t.Errorf("expected error: '%v', got: '%v'", test.err, err)
 			}
@@ -239,7 +552,138 @@ func TestEnsureRoute53RecordSet(t *testing.T) {
 				if err != nil {
 					t.Errorf("expected error: nil, got: %v", err)
 				}
+				if name == "TestNotFound" && rrs != nil {
+					t.Errorf("expected record set: nil, got: %v", rrs)
+				}
+				if name == "TestSuccess" && rrs == nil {
+					t.Errorf("expected record set, got: nil")
+				}
 			}
 		})
 	}
 }
+
+func TestGetRoute53RecordSetIdentifier(t *testing.T) {
+	t.Parallel()
+
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			recordFunc: func(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+				if id := aws.StringValue(input.StartRecordIdentifier); id != "us-east-1" {
+					return nil, fmt.Errorf("unexpected start record identifier: %s", id)
+				}
+
+				// the next record set is returned if the identifier does not exist
+				return &route53.ListResourceRecordSetsOutput{
+					ResourceRecordSets: []*route53.ResourceRecordSet{
+						{
+							Name:          aws.String("syscll.org."),
+							Type:          aws.String(route53.RRTypeA),
+							SetIdentifier: aws.String("us-west-2"),
+						},
+					},
+				}, nil
+			},
+		},
+	}
+
+	rrs, err := mgr.getRoute53RecordSet(context.Background(), "zone-1", "syscll.org", route53.RRTypeA, "us-east-1")
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if rrs != nil {
+		t.Errorf("expected record set: nil, got: %v", rrs)
+	}
+}
+
+func TestGetConflictingRoute53RecordSet(t *testing.T) {
+	t.Parallel()
+
+	recordSet := func(name, setID string) *route53.ResourceRecordSet {
+		rrs := &route53.ResourceRecordSet{
+			Name: aws.String(name),
+			Type: aws.String(route53.RRTypeA),
+		}
+		if setID != "" {
+			rrs.SetIdentifier = aws.String(setID)
+		}
+		return rrs
+	}
+
+	testTable := map[string]struct {
+		listed   *route53.ResourceRecordSet
+		setID    string
+		conflict bool
+	}{
+		"TestSimpleNextToRouted": {
+			listed:   recordSet("syscll.org.", ""),
+			setID:    "us-east-1",
+			conflict: true,
+		},
+		"TestRoutedNextToSimple": {
+			listed:   recordSet("syscll.org.", "us-east-1"),
+			conflict: true,
+		},
+		"TestRoutedNextToRouted": {
+			listed: recordSet("syscll.org.", "us-east-1"),
+			setID:  "eu-west-1",
+		},
+		"TestOtherHost": {
+			listed: recordSet("www.syscll.org.", ""),
+			setID:  "us-east-1",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			mgr := awsManager{
+				route53: mockRoute53ReadWriter{
+					recordFunc: func(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+						if input.StartRecordIdentifier != nil {
+							return nil, fmt.Errorf("unexpected start record identifier: %s", aws.StringValue(input.StartRecordIdentifier))
+						}
+						return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []*route53.ResourceRecordSet{test.listed}}, nil
+					},
+				},
+			}
+
+			rrs, err := mgr.getConflictingRoute53RecordSet(context.Background(), "zone-1", "syscll.org", route53.RRTypeA, test.setID)
+			if err != nil {
+				t.Fatalf("expected error: nil, got: %v", err)
+			}
+			if (rrs != nil) != test.conflict {
+				t.Errorf("expected conflict: %t, got: %v", test.conflict, rrs)
+			}
+		})
+	}
+}
+
+func TestAWSConfigDefaults(t *testing.T) {
+	t.Parallel()
+
+	var cfg config
+	cfg.setDefaults()
+
+	retryer := cfg.AWS.Retry.retryer()
+	if retryer.NumMaxRetries != defaultAWSMaxRetries || retryer.MinRetryDelay != defaultAWSMinRetryDelay || retryer.MaxRetryDelay != defaultAWSMaxRetryDelay {
+		t.Errorf("unexpected default retryer: %+v", retryer)
+	}
+
+	// throttled requests wait at least as long as the sdk default
+	if retryer.MinThrottleDelay != client.DefaultRetryerMinThrottleDelay || retryer.MaxThrottleDelay != defaultAWSMaxRetryDelay {
+		t.Errorf("unexpected default throttle delays: %+v", retryer)
+	}
+
+	httpClient := cfg.AWS.HTTP.client()
+	if httpClient.Timeout != defaultAWSHTTPTimeout {
+		t.Errorf("expected timeout: %s, got: %s", defaultAWSHTTPTimeout, httpClient.Timeout)
+	}
+
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok || transport == http.DefaultTransport || transport.MaxIdleConnsPerHost != defaultAWSMaxIdleConnsPerHost || transport.IdleConnTimeout != defaultAWSIdleConnTimeout {
+		t.Errorf("expected a custom transport, got: %+v", httpClient.Transport)
+	}
+}
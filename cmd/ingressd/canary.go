@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// set identifier of the weighted record set of the primary pool
+	poolPrimary = "primary"
+
+	// set identifier of the weighted record set of the canary pool
+	poolCanary = "canary"
+
+	// combined weight of the primary and canary record sets, so weights are percentages
+	canaryTotalWeight = 100
+)
+
+// Prometheus gauge for storing the current canary weight of each weighted record
+var canaryWeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ingressd_canary_weight",
+	Help: "Current percentage of traffic sent to the canary pool of a weighted record",
+}, []string{"record"})
+
+// canaryConfig defines the canary pool of a weighted record and the share of
+// traffic it receives
+type canaryConfig struct {
+	// ec2 tags selecting the canary pool from the record's source
+	Selector tagSelector `yaml:"selector" json:"selector"`
+
+	// percentage of traffic sent to the canary pool, before any schedule step
+	Weight int64 `yaml:"weight" json:"weight"`
+
+	// steps that shift the canary weight over time. The weight moves linearly
+	// from one step to the next, and stays at the weight of the last step
+	Schedule []canaryStep `yaml:"schedule" json:"schedule"`
+}
+
+// canaryStep is the canary weight at a point in time
+type canaryStep struct {
+	At     time.Time `yaml:"at" json:"at"`
+	Weight int64     `yaml:"weight" json:"weight"`
+}
+
+// weight returns the percentage of traffic sent to the canary pool at a given time
+func (c canaryConfig) weight(now time.Time) int64 {
+	if len(c.Schedule) == 0 || now.Before(c.Schedule[0].At) {
+		return c.Weight
+	}
+
+	for i := 1; i < len(c.Schedule); i++ {
+		from, to := c.Schedule[i-1], c.Schedule[i]
+		if now.Before(to.At) {
+			progress := float64(now.Sub(from.At)) / float64(to.At.Sub(from.At))
+			return from.Weight + int64(math.Round(progress*float64(to.Weight-from.Weight)))
+		}
+	}
+
+	return c.Schedule[len(c.Schedule)-1].Weight
+}
+
+func (c canaryConfig) validate() error {
+	if err := c.Selector.validate(); err != nil {
+		return fmt.Errorf("selector.%w", err)
+	}
+
+	if c.Weight < 0 || c.Weight > canaryTotalWeight {
+		return fmt.Errorf("weight: must be between 0 and %d", canaryTotalWeight)
+	}
+
+	for i, step := range c.Schedule {
+		if step.At.IsZero() {
+			return fmt.Errorf("schedule[%d].at: required", i)
+		}
+
+		if i > 0 && !step.At.After(c.Schedule[i-1].At) {
+			return fmt.Errorf("schedule[%d].at: must be after the previous step", i)
+		}
+
+		if step.Weight < 0 || step.Weight > canaryTotalWeight {
+			return fmt.Errorf("schedule[%d].weight: must be between 0 and %d", i, canaryTotalWeight)
+		}
+	}
+
+	return nil
+}
+
+// excludeTargets returns the targets whose ip addrs are not in excluded
+func excludeTargets(targets, excluded []target) []target {
+	if len(excluded) == 0 {
+		return targets
+	}
+
+	ips := make(map[string]bool, len(excluded))
+	for _, t := range excluded {
+		ips[t.IP.String()] = true
+	}
+
+	var filtered []target
+	for _, t := range targets {
+		if !ips[t.IP.String()] {
+			filtered = append(filtered, t)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCanaryWeight(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+
+	canary := canaryConfig{
+		Weight: 5,
+		Schedule: []canaryStep{
+			{At: start, Weight: 10},
+			{At: start.Add(time.Hour), Weight: 50},
+			{At: start.Add(2 * time.Hour), Weight: 100},
+		},
+	}
+
+	testTable := map[string]struct {
+		now      time.Time
+		expected int64
+	}{
+		"TestBeforeSchedule":  {now: start.Add(-time.Minute), expected: 5},
+		"TestFirstStep":       {now: start, expected: 10},
+		"TestBetweenSteps":    {now: start.Add(30 * time.Minute), expected: 30},
+		"TestSecondStep":      {now: start.Add(time.Hour), expected: 50},
+		"TestBetweenLastStep": {now: start.Add(90 * time.Minute), expected: 75},
+		"TestAfterSchedule":   {now: start.Add(24 * time.Hour), expected: 100},
+	}
+
+	for name, test := range testTable {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if weight := canary.weight(test.now); weight != test.expected {
+				t.Errorf("expected weight: %d, got: %d", test.expected, weight)
+			}
+		})
+	}
+
+	if weight := (canaryConfig{Weight: 20}).weight(start); weight != 20 {
+		t.Errorf("expected weight without a schedule: 20, got: %d", weight)
+	}
+}
+
+func TestCanaryValidate(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)
+	sel := tagSelector{Tags: []tagFilter{{Key: "Pool", Value: "canary"}}}
+
+	testTable := map[string]struct {
+		canary canaryConfig
+		err    string
+	}{
+		"TestSuccess": {
+			canary: canaryConfig{Selector: sel, Weight: 10, Schedule: []canaryStep{{At: start, Weight: 50}}},
+		},
+		"TestMissingSelectorError": {
+			canary: canaryConfig{Weight: 10},
+			err:    "selector.tags: at least one tag is required",
+		},
+		"TestWeightError": {
+			canary: canaryConfig{Selector: sel, Weight: 101},
+			err:    "weight: must be between 0 and 100",
+		},
+		"TestScheduleOrderError": {
+			canary: canaryConfig{Selector: sel, Schedule: []canaryStep{{At: start, Weight: 10}, {At: start, Weight: 20}}},
+			err:    "schedule[1].at: must be after the previous step",
+		},
+		"TestScheduleMissingTimeError": {
+			canary: canaryConfig{Selector: sel, Schedule: []canaryStep{{Weight: 10}}},
+			err:    "schedule[0].at: required",
+		},
+	}
+
+	for name, test := range testTable {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := test.canary.validate()
+			if test.err == "" && err != nil {
+				t.Errorf("expected error: nil, got: %v", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+			}
+		})
+	}
+}
+
+func TestCanaryScheduleDecode(t *testing.T) {
+	t.Parallel()
+
+	var canary canaryConfig
+	yaml := "weight: 5\nschedule:\n- at: 2026-10-20T09:00:00Z\n  weight: 10\n"
+	if err := decodeFile("config.yaml", []byte(yaml), &canary); err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	if len(canary.Schedule) != 1 || !canary.Schedule[0].At.Equal(time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected schedule: %+v", canary.Schedule)
+	}
+}
+
+func TestExcludeTargets(t *testing.T) {
+	t.Parallel()
+
+	targets := []target{{IP: net.ParseIP("192.168.0.1")}, {IP: net.ParseIP("192.168.0.2")}}
+	canaries := []target{{IP: net.ParseIP("192.168.0.2")}}
+
+	if filtered := excludeTargets(targets, canaries); len(filtered) != 1 || !filtered[0].IP.Equal(net.ParseIP("192.168.0.1")) {
+		t.Errorf("expected canary targets to be excluded, got: %v", filtered)
+	}
+	if filtered := excludeTargets(targets, nil); len(filtered) != 2 {
+		t.Errorf("expected no targets to be excluded, got: %v", filtered)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// changeSet collects the sources that changed between polls, so that only
+// their records are reconciled early. Notifications are coalesced until taken
+type changeSet struct {
+	mu      sync.Mutex
+	sources map[string]bool
+
+	// signalled when the set becomes non-empty
+	c chan struct{}
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{
+		sources: make(map[string]bool),
+		c:       make(chan struct{}, 1),
+	}
+}
+
+// notify marks the given sources as changed
+func (s *changeSet) notify(sources ...string) {
+	if len(sources) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	for _, name := range sources {
+		s.sources[name] = true
+	}
+	s.mu.Unlock()
+
+	// a pending signal already covers this change
+	select {
+	case s.c <- struct{}{}:
+	default:
+	}
+}
+
+// ready is signalled whenever sources have changed since the last take
+func (s *changeSet) ready() <-chan struct{} {
+	return s.c
+}
+
+// take returns the sorted names of the changed sources and resets the set
+func (s *changeSet) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	s.sources = make(map[string]bool)
+
+	return names
+}
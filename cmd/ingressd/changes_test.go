@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChangeSet(t *testing.T) {
+	t.Parallel()
+
+	s := newChangeSet()
+
+	// notifications without sources are ignored
+	s.notify()
+	select {
+	case <-s.ready():
+		t.Errorf("expected no signal without sources")
+	default:
+	}
+
+	s.notify("b")
+	s.notify("a", "b")
+
+	select {
+	case <-s.ready():
+	case <-time.After(time.Second):
+		t.Fatalf("expected a signal")
+	}
+
+	if sources := s.take(); fmt.Sprint(sources) != "[a b]" {
+		t.Errorf("expected sources: [a b], got: %v", sources)
+	}
+	if sources := s.take(); len(sources) != 0 {
+		t.Errorf("expected no sources after take, got: %v", sources)
+	}
+}
@@ -0,0 +1,1201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// default poll interval for route53 updates
+	defaultPollInterval = 30 * time.Second
+
+	// default port to bind local http server to
+	defaultPort = 8081
+
+	// default number of ip addrs health checked at once during a poll
+	defaultHealthCheckConcurrency = 32
+
+	// default ttl of managed route53 records
+	defaultRecordTTL = 60
+
+	// default ip family of managed route53 records
+	defaultIPFamily = ipFamilyV4
+
+	// default ttl of the cached list of route53 hosted zones
+	defaultZoneCacheTTL = 5 * time.Minute
+
+	// shortest ttl of the cached list of route53 hosted zones
+	minZoneCacheTTL = time.Second
+
+	// default timeout of a single health check request
+	defaultHealthCheckTimeout = 10 * time.Second
+
+	// default path of a health check request
+	defaultHealthCheckPath = "/"
+
+	// default time to wait, beyond the record ttl, before a drained instance is terminated
+	defaultDrainGracePeriod = 30 * time.Second
+
+	// default number of consecutive passed checks before an ip addr is healthy
+	defaultHealthCheckRise = 2
+
+	// default number of consecutive failed checks before an ip addr is unhealthy
+	defaultHealthCheckFall = 3
+
+	// default maximum number of retries of a failed aws request
+	defaultAWSMaxRetries = 3
+
+	// default shortest delay before retrying a failed aws request
+	defaultAWSMinRetryDelay = 30 * time.Millisecond
+
+	// default longest delay before retrying a failed aws request
+	defaultAWSMaxRetryDelay = 20 * time.Second
+
+	// default timeout of a single aws request
+	defaultAWSHTTPTimeout = 60 * time.Second
+
+	// default maximum number of idle connections to each aws endpoint
+	defaultAWSMaxIdleConnsPerHost = 10
+
+	// default time an idle connection to aws is kept open
+	defaultAWSIdleConnTimeout = 90 * time.Second
+
+	// default maximum number of response body bytes read for assertions
+	defaultAssertBodyLimit = 64 * 1024
+
+	// name of the discovery source built from environment variables
+	envSourceName = "default"
+)
+
+const (
+	// only publish ipv4 addrs as an A record set
+	ipFamilyV4 = "v4"
+
+	// only publish ipv6 addrs as an AAAA record set
+	ipFamilyV6 = "v6"
+
+	// publish both A and AAAA record sets
+	ipFamilyDual = "dual"
+)
+
+const (
+	// publish the public ipv4 addr of an instance
+	addressPublic = "public"
+
+	// publish the private ipv4 addr of an instance
+	addressPrivate = "private"
+
+	// publish the elastic ipv4 addrs associated with an instance
+	addressElastic = "eip"
+)
+
+// hostnameRegexp matches a valid, fully qualified or relative, dns hostname
+var hostnameRegexp = regexp.MustCompile(`^([a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9])?\.?$`)
+
+// zoneIDRegexp matches a route53 hosted zone id, with or without the /hostedzone/ prefix
+var zoneIDRegexp = regexp.MustCompile(`^(/hostedzone/)?[A-Z0-9]+$`)
+
+// tokenRegexp matches a valid http token, used for methods and header names
+var tokenRegexp = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9a-zA-Z-]+$")
+
+// config is the full ingressd configuration, either loaded from a yaml/json
+// file or built from environment variables
+type config struct {
+	// poll interval for route53 updates
+	PollInterval duration `yaml:"poll_interval" json:"poll_interval"`
+
+	// how long a single poll may take before it is cancelled, default: the poll interval
+	PollTimeout duration `yaml:"poll_timeout" json:"poll_timeout"`
+
+	// port to bind local http server to
+	Port int `yaml:"port" json:"port"`
+
+	// how many ip addrs are health checked at once during a poll, default: 32
+	HealthCheckConcurrency int `yaml:"health_check_concurrency" json:"health_check_concurrency"`
+
+	// how long the list of route53 hosted zones is cached before being refreshed
+	ZoneCacheTTL duration `yaml:"zone_cache_ttl" json:"zone_cache_ttl"`
+
+	// named sources used to discover ingress ip addrs
+	Sources []sourceConfig `yaml:"sources" json:"sources"`
+
+	// route53 records to be kept up to date
+	Records []recordConfig `yaml:"records" json:"records"`
+
+	// sqs queues receiving ec2 instance state-change notifications, which
+	// trigger an early poll of the affected records
+	Events []eventsConfig `yaml:"events" json:"events"`
+
+	// named aws credentials, referenced by aws services and records
+	Credentials map[string]credentialsConfig `yaml:"credentials" json:"credentials"`
+
+	// named credentials used by each aws service
+	AWS awsConfig `yaml:"aws" json:"aws"`
+}
+
+// eventsConfig defines an sqs queue receiving ec2 instance state-change
+// notifications from eventbridge
+type eventsConfig struct {
+	// aws region of the queue
+	Region string `yaml:"region" json:"region"`
+
+	// url of the sqs queue
+	QueueURL string `yaml:"queue_url" json:"queue_url"`
+}
+
+// route53Credentials returns the name of the credentials managing the hosted
+// zone of a record, those of route53 unless the record has its own
+func (cfg config) route53Credentials(record recordConfig) string {
+	if record.DNS.Credentials != "" {
+		return record.DNS.Credentials
+	}
+	return cfg.AWS.Route53
+}
+
+// only returns a copy of the config limited to the given sources and the
+// records that use them
+func (cfg config) only(sources []string) config {
+	names := make(map[string]bool, len(sources))
+	for _, name := range sources {
+		names[name] = true
+	}
+
+	limited := cfg
+	limited.Sources = nil
+	for _, src := range cfg.Sources {
+		if names[src.Name] {
+			limited.Sources = append(limited.Sources, src)
+		}
+	}
+
+	limited.Records = nil
+	for _, record := range cfg.Records {
+		if names[record.Source] {
+			limited.Records = append(limited.Records, record)
+		}
+	}
+
+	return limited
+}
+
+// sourceConfig defines a named discovery source. Exactly one source type
+// must be configured
+type sourceConfig struct {
+	// unique name of the source, referenced by records
+	Name string `yaml:"name" json:"name"`
+
+	// discover the ip addrs of tagged ec2 instances
+	EC2 *ec2SourceConfig `yaml:"ec2" json:"ec2"`
+
+	// discover the ip addrs of the instances of auto scaling groups
+	ASG *asgSourceConfig `yaml:"asg" json:"asg"`
+
+	// publish a fixed list of ip addrs
+	Static *staticSourceConfig `yaml:"static" json:"static"`
+
+	// publish the ip addrs listed in a yaml or json file, reloaded on change
+	File *fileSourceConfig `yaml:"file" json:"file"`
+}
+
+// region returns the primary aws region of a source, used for route53 and
+// lifecycle hook queues
+func (src sourceConfig) region() string {
+	return src.regions()[0]
+}
+
+// regions returns every aws region of a source. Sources without a region only
+// use route53, a global service served from us-east-1
+func (src sourceConfig) regions() []string {
+	var regions []string
+	switch {
+	case src.EC2 != nil:
+		regions = regionList(src.EC2.Region, src.EC2.Regions)
+	case src.ASG != nil:
+		regions = regionList(src.ASG.Region, src.ASG.Regions)
+	}
+
+	if len(regions) == 0 {
+		return []string{defaultRoute53Region}
+	}
+
+	return regions
+}
+
+// regionList combines a single region with a list of regions, in order and
+// without duplicates
+func regionList(region string, regions []string) []string {
+	var list []string
+	for _, r := range append([]string{region}, regions...) {
+		if r != "" && !containsString(list, r) {
+			list = append(list, r)
+		}
+	}
+
+	return list
+}
+
+// staticSourceConfig defines a fixed list of ip addrs. It is also the format
+// of the file read by a file source
+type staticSourceConfig struct {
+	// ip addrs to publish
+	IPs []string `yaml:"ips" json:"ips"`
+
+	// ip addrs to publish, along with metadata
+	Targets []targetConfig `yaml:"targets" json:"targets"`
+}
+
+// targetConfig is a single static ip addr and its metadata
+type targetConfig struct {
+	IP     string            `yaml:"ip" json:"ip"`
+	AZ     string            `yaml:"az" json:"az"`
+	Labels map[string]string `yaml:"labels" json:"labels"`
+}
+
+// targets parses the ip addrs of a static source
+func (s staticSourceConfig) targets() ([]target, error) {
+	targets := make([]target, 0, len(s.IPs)+len(s.Targets))
+	for i, v := range s.IPs {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("ips[%d]: invalid ip addr: %s", i, v)
+		}
+		targets = append(targets, target{IP: ip})
+	}
+
+	for i, t := range s.Targets {
+		ip := net.ParseIP(t.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("targets[%d].ip: invalid ip addr: %s", i, t.IP)
+		}
+		targets = append(targets, target{IP: ip, AZ: t.AZ, Labels: t.Labels})
+	}
+
+	return targets, nil
+}
+
+// fileSourceConfig defines a yaml or json file listing ip addrs, in the same
+// format as a static source
+type fileSourceConfig struct {
+	// path of the file, files with a .json extension are decoded as json
+	Path string `yaml:"path" json:"path"`
+}
+
+// ec2SourceConfig defines how to query ec2 for ingress instances
+type ec2SourceConfig struct {
+	// aws region of ec2 instances to query
+	Region string `yaml:"region" json:"region"`
+
+	// aws regions of ec2 instances to query, each queried concurrently
+	Regions []string `yaml:"regions" json:"regions"`
+
+	// single ec2 tag to query for instances, shorthand for a tags list of one
+	Tag *tagFilter `yaml:"tag" json:"tag"`
+
+	// ec2 tags to query for instances
+	tagSelector `yaml:",inline"`
+
+	// ipv4 addr published for each instance: public, private or eip, default: public
+	Address string `yaml:"address" json:"address"`
+
+	// only publish the addrs of the network interface attached at this device index
+	DeviceIndex *int64 `yaml:"device_index" json:"device_index"`
+}
+
+// selector returns the tag selector of the source, including any single tag
+func (src ec2SourceConfig) selector() tagSelector {
+	sel := src.tagSelector
+	if src.Tag != nil {
+		sel.Tags = append([]tagFilter{*src.Tag}, sel.Tags...)
+	}
+
+	return sel
+}
+
+// asgSourceConfig defines which auto scaling groups to list for ingress instances
+type asgSourceConfig struct {
+	// aws region of the auto scaling groups
+	Region string `yaml:"region" json:"region"`
+
+	// aws regions of the auto scaling groups, each queried concurrently
+	Regions []string `yaml:"regions" json:"regions"`
+
+	// names of the auto scaling groups
+	Names []string `yaml:"names" json:"names"`
+
+	// ipv4 addr published for each instance: public, private or eip, default: public
+	Address string `yaml:"address" json:"address"`
+
+	// only publish the addrs of the network interface attached at this device index
+	DeviceIndex *int64 `yaml:"device_index" json:"device_index"`
+
+	// drain instances on scale-in using termination lifecycle hook notifications
+	LifecycleHook *lifecycleHookConfig `yaml:"lifecycle_hook" json:"lifecycle_hook"`
+}
+
+// lifecycleHookConfig defines the sqs queue receiving termination lifecycle hook
+// notifications and how long terminating instances are drained for
+type lifecycleHookConfig struct {
+	// url of the sqs queue in the region of the source
+	QueueURL string `yaml:"queue_url" json:"queue_url"`
+
+	// time to wait, beyond the longest record ttl, before completing the lifecycle action, default: 30s
+	GracePeriod duration `yaml:"grace_period" json:"grace_period"`
+}
+
+// recordConfig defines a single route53 record and how its ip addrs are
+// discovered and checked
+type recordConfig struct {
+	// fully qualified host name of the record, e.g: ingress.syscll.org
+	Name string `yaml:"name" json:"name"`
+
+	// name of the source used to discover ip addrs for this record
+	Source string `yaml:"source" json:"source"`
+
+	// ec2 tags to query for instances, replacing those of the ec2 source
+	Selector *tagSelector `yaml:"selector" json:"selector"`
+
+	// health check settings performed against each ip addr
+	HealthCheck healthCheckConfig `yaml:"health_check" json:"health_check"`
+
+	// route53 options for this record
+	DNS dnsConfig `yaml:"dns" json:"dns"`
+
+	// route53 routing policy of this record
+	Routing routingConfig `yaml:"routing" json:"routing"`
+
+	// minimum healthy ip addrs required before the record set is changed
+	Safety safetyConfig `yaml:"safety" json:"safety"`
+}
+
+// safetyConfig defines the minimum number of discovered ip addrs that must stay
+// published. If fewer are healthy, the current record set is kept unchanged
+type safetyConfig struct {
+	// minimum absolute number of healthy ip addrs
+	MinHealthy int `yaml:"min_healthy" json:"min_healthy"`
+
+	// minimum percentage of discovered ip addrs that must be healthy, rounded up
+	MinHealthyPercent float64 `yaml:"min_healthy_percent" json:"min_healthy_percent"`
+}
+
+// healthCheckConfig defines how health checks are performed for a record
+type healthCheckConfig struct {
+	// number of successful responses required, per scheme, for an ip addr to pass
+	Attempts int `yaml:"attempts" json:"attempts"`
+
+	// timeout of a single health check request
+	Timeout duration `yaml:"timeout" json:"timeout"`
+
+	// consecutive passed checks before an unhealthy ip addr is published, default: 2
+	Rise int `yaml:"rise" json:"rise"`
+
+	// consecutive failed checks before a healthy ip addr is removed, default: 3
+	Fall int `yaml:"fall" json:"fall"`
+
+	// path, and optional query, of the health check request, default: /
+	Path string `yaml:"path" json:"path"`
+
+	// http method of the health check request, default: GET
+	Method string `yaml:"method" json:"method"`
+
+	// accepted response status codes or ranges, e.g: 200 or 200-399, default: 200
+	StatusCodes []statusRange `yaml:"status_codes" json:"status_codes"`
+
+	// additional request headers, a Host header overrides the record name
+	Headers map[string]string `yaml:"headers" json:"headers"`
+
+	// schemes to check, each must pass, default: http and https
+	Schemes []string `yaml:"schemes" json:"schemes"`
+
+	// optional assertions on the response, checked after the status code
+	Assert *assertConfig `yaml:"assert" json:"assert"`
+}
+
+// assertConfig defines optional assertions on a health check response
+type assertConfig struct {
+	// response body must contain the given substring
+	BodyContains string `yaml:"body_contains" json:"body_contains"`
+
+	// response body must match the given regular expression
+	BodyRegex pattern `yaml:"body_regex" json:"body_regex"`
+
+	// maximum number of body bytes read for body and json assertions, default: 64KiB
+	BodyLimit int64 `yaml:"body_limit" json:"body_limit"`
+
+	// required response headers, an empty value only asserts presence
+	Headers map[string]string `yaml:"headers" json:"headers"`
+
+	// response body must be json with the given field value
+	JSON *jsonAssertConfig `yaml:"json" json:"json"`
+}
+
+// jsonAssertConfig asserts the value of a single json response field
+type jsonAssertConfig struct {
+	// dot separated path of the field, e.g: status or checks.0.status
+	Field string `yaml:"field" json:"field"`
+
+	// expected value of the field, compared as a string
+	Value string `yaml:"value" json:"value"`
+}
+
+// acceptsStatus reports whether a response status code is accepted
+func (hc healthCheckConfig) acceptsStatus(code int) bool {
+	for _, r := range hc.StatusCodes {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+
+	return false
+}
+
+// statusRange is an inclusive range of http status codes, decoded from either
+// a single code (200) or a range ("200-299")
+type statusRange struct {
+	Min int
+	Max int
+}
+
+// UnmarshalYAML decodes a status range from a yaml int or string
+func (r *statusRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+
+	return r.parse(fmt.Sprint(v))
+}
+
+// UnmarshalJSON decodes a status range from a json number or string
+func (r *statusRange) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	return r.parse(fmt.Sprint(v))
+}
+
+func (r *statusRange) parse(s string) error {
+	parts := strings.SplitN(s, "-", 2)
+
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return fmt.Errorf("invalid status code: %s", s)
+	}
+
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return fmt.Errorf("invalid status code: %s", s)
+		}
+	}
+
+	r.Min, r.Max = min, max
+
+	return nil
+}
+
+// dnsConfig defines route53 options for a record
+type dnsConfig struct {
+	// ttl of the record set in seconds, default: 60
+	TTL *int64 `yaml:"ttl" json:"ttl"`
+
+	// hosted zone id of the record, looked up from the record name if empty
+	ZoneID string `yaml:"zone_id" json:"zone_id"`
+
+	// type of hosted zone to look up: public, private or empty for either
+	ZoneType string `yaml:"zone_type" json:"zone_type"`
+
+	// only look up private hosted zones associated with this vpc id
+	VPCID string `yaml:"vpc_id" json:"vpc_id"`
+
+	// record sets to publish: v4 (A), v6 (AAAA) or dual (both), default: v4
+	IPFamily string `yaml:"ip_family" json:"ip_family"`
+
+	// named credentials used for the hosted zone of the record, default: those of route53
+	Credentials string `yaml:"credentials" json:"credentials"`
+}
+
+// rrTypes returns the types of the record sets managed for the ip family
+func (d dnsConfig) rrTypes() []string {
+	switch d.IPFamily {
+	case ipFamilyV6:
+		return []string{route53.RRTypeAaaa}
+	case ipFamilyDual:
+		return []string{route53.RRTypeA, route53.RRTypeAaaa}
+	default:
+		return []string{route53.RRTypeA}
+	}
+}
+
+// pattern wraps regexp.Regexp so it can be decoded, and validated, from a string
+type pattern struct {
+	*regexp.Regexp
+}
+
+// UnmarshalYAML decodes a pattern from a yaml string
+func (p *pattern) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	return p.parse(s)
+}
+
+// UnmarshalJSON decodes a pattern from a json string
+func (p *pattern) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	return p.parse(s)
+}
+
+func (p *pattern) parse(s string) error {
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return fmt.Errorf("invalid regular expression: %s: %w", s, err)
+	}
+	p.Regexp = re
+
+	return nil
+}
+
+// duration wraps time.Duration so it can be decoded from strings such as "30s"
+type duration struct {
+	time.Duration
+}
+
+// UnmarshalYAML decodes a duration from a yaml string
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+// UnmarshalJSON decodes a duration from a json string
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	return d.parse(s)
+}
+
+func (d *duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %s", s)
+	}
+	d.Duration = v
+
+	return nil
+}
+
+// loadConfig reads, defaults and validates the config file at a given path.
+// Files with a .json extension are decoded as json, all others as yaml
+func loadConfig(path string) (config, error) {
+	var cfg config
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := decodeFile(path, b, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding config file: %w", err)
+	}
+
+	cfg.setDefaults()
+
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// decodeFile strictly decodes the contents of a file, rejecting unknown fields.
+// Files with a .json extension are decoded as json, all others as yaml
+func decodeFile(path string, b []byte, v interface{}) error {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	}
+
+	return yaml.UnmarshalStrict(b, v)
+}
+
+// configFromEnv builds a config from the legacy environment variables,
+// mapping them onto a single ec2 source shared by every record
+func configFromEnv() (config, error) {
+	var cfg config
+
+	// parse aws ec2 tags into a selector
+	sel, err := parseTagSelector(os.Getenv(envAWSEC2Tag))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid aws ec2 tag: %s: %w", envAWSEC2Tag, err)
+	}
+
+	cfg.Sources = []sourceConfig{
+		{
+			Name: envSourceName,
+			EC2: &ec2SourceConfig{
+				Region:      os.Getenv(envAWSRegion),
+				tagSelector: sel,
+			},
+		},
+	}
+
+	// parse route53 records
+	for _, record := range strings.Split(os.Getenv(envAWSRoute53Records), ",") {
+		if record = strings.TrimSpace(record); record == "" {
+			continue
+		}
+		cfg.Records = append(cfg.Records, recordConfig{
+			Name:   record,
+			Source: envSourceName,
+		})
+	}
+
+	// parse poll interval
+	if p := os.Getenv(envPollInterval); p != "" {
+		if err := cfg.PollInterval.parse(p); err != nil {
+			return cfg, fmt.Errorf("invalid poll interval: %w", err)
+		}
+	}
+
+	// parse port
+	if p := os.Getenv(envPort); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid port: %s: %w", p, err)
+		}
+		cfg.Port = port
+	}
+
+	cfg.setDefaults()
+
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid environment config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// setDefaults populates any unset optional fields
+func (cfg *config) setDefaults() {
+	if cfg.PollInterval.Duration == 0 {
+		cfg.PollInterval.Duration = defaultPollInterval
+	}
+
+	if cfg.PollTimeout.Duration == 0 {
+		cfg.PollTimeout.Duration = cfg.PollInterval.Duration
+	}
+
+	if cfg.Port == 0 {
+		cfg.Port = defaultPort
+	}
+
+	if cfg.HealthCheckConcurrency == 0 {
+		cfg.HealthCheckConcurrency = defaultHealthCheckConcurrency
+	}
+
+	if cfg.ZoneCacheTTL.Duration == 0 {
+		cfg.ZoneCacheTTL.Duration = defaultZoneCacheTTL
+	}
+
+	for i := range cfg.Sources {
+		if src := cfg.Sources[i].EC2; src != nil && src.Address == "" {
+			src.Address = addressPublic
+		}
+
+		if src := cfg.Sources[i].ASG; src != nil {
+			if src.Address == "" {
+				src.Address = addressPublic
+			}
+
+			if hook := src.LifecycleHook; hook != nil && hook.GracePeriod.Duration == 0 {
+				hook.GracePeriod.Duration = defaultDrainGracePeriod
+			}
+		}
+	}
+
+	if cfg.AWS.Retry.MaxRetries == nil {
+		cfg.AWS.Retry.MaxRetries = aws.Int(defaultAWSMaxRetries)
+	}
+
+	if cfg.AWS.Retry.MinDelay.Duration == 0 {
+		cfg.AWS.Retry.MinDelay.Duration = defaultAWSMinRetryDelay
+	}
+
+	if cfg.AWS.Retry.MaxDelay.Duration == 0 {
+		cfg.AWS.Retry.MaxDelay.Duration = defaultAWSMaxRetryDelay
+	}
+
+	if cfg.AWS.HTTP.Timeout.Duration == 0 {
+		cfg.AWS.HTTP.Timeout.Duration = defaultAWSHTTPTimeout
+	}
+
+	if cfg.AWS.HTTP.MaxIdleConnsPerHost == 0 {
+		cfg.AWS.HTTP.MaxIdleConnsPerHost = defaultAWSMaxIdleConnsPerHost
+	}
+
+	if cfg.AWS.HTTP.IdleConnTimeout.Duration == 0 {
+		cfg.AWS.HTTP.IdleConnTimeout.Duration = defaultAWSIdleConnTimeout
+	}
+
+	for name, c := range cfg.Credentials {
+		if c.RoleARN != "" && c.SessionName == "" {
+			c.SessionName = defaultRoleSessionName
+			cfg.Credentials[name] = c
+		}
+	}
+
+	for i := range cfg.Records {
+		r := &cfg.Records[i]
+
+		r.HealthCheck.setDefaults()
+
+		if r.DNS.TTL == nil {
+			r.DNS.TTL = aws.Int64(defaultRecordTTL)
+		}
+
+		if r.DNS.IPFamily == "" {
+			r.DNS.IPFamily = defaultIPFamily
+		}
+
+		if r.Routing.Policy == "" {
+			r.Routing.Policy = routingSimple
+		}
+	}
+}
+
+// validate ensures the config is complete and consistent. The returned error
+// names the offending field
+func (cfg config) validate() error {
+	if cfg.PollInterval.Duration < 0 {
+		return fmt.Errorf("poll_interval: must be positive")
+	}
+
+	if cfg.PollTimeout.Duration < 0 {
+		return fmt.Errorf("poll_timeout: must be positive")
+	}
+
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return fmt.Errorf("port: must be between 1 and 65535, got: %d", cfg.Port)
+	}
+
+	if cfg.HealthCheckConcurrency < 1 {
+		return fmt.Errorf("health_check_concurrency: must be at least 1")
+	}
+
+	if cfg.ZoneCacheTTL.Duration < minZoneCacheTTL {
+		return fmt.Errorf("zone_cache_ttl: must be at least %s, got: %s", minZoneCacheTTL, cfg.ZoneCacheTTL.Duration)
+	}
+
+	names := make([]string, 0, len(cfg.Credentials))
+	for name := range cfg.Credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("credentials: name must not be empty")
+		}
+
+		if err := cfg.Credentials[name].validate(); err != nil {
+			return fmt.Errorf("credentials.%s.%w", name, err)
+		}
+	}
+
+	services := []struct{ name, credentials string }{
+		{"ec2", cfg.AWS.EC2},
+		{"autoscaling", cfg.AWS.AutoScaling},
+		{"sqs", cfg.AWS.SQS},
+		{"route53", cfg.AWS.Route53},
+	}
+	for _, svc := range services {
+		if _, ok := cfg.Credentials[svc.credentials]; svc.credentials != "" && !ok {
+			return fmt.Errorf("aws.%s: unknown credentials: %s", svc.name, svc.credentials)
+		}
+	}
+
+	if err := cfg.AWS.validate(); err != nil {
+		return fmt.Errorf("aws.%w", err)
+	}
+
+	if len(cfg.Sources) == 0 {
+		return fmt.Errorf("sources: at least one source is required")
+	}
+
+	sources := make(map[string]sourceConfig)
+	for i, src := range cfg.Sources {
+		if err := src.validate(); err != nil {
+			return fmt.Errorf("sources[%d]: %w", i, err)
+		}
+
+		if _, ok := sources[src.Name]; ok {
+			return fmt.Errorf("sources[%d]: duplicate name: %s", i, src.Name)
+		}
+		sources[src.Name] = src
+	}
+
+	if len(cfg.Records) == 0 {
+		return fmt.Errorf("records: at least one record is required")
+	}
+
+	records := make(map[string]bool)
+	for i, r := range cfg.Records {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("records[%d]: %w", i, err)
+		}
+
+		src, ok := sources[r.Source]
+		if !ok {
+			return fmt.Errorf("records[%d].source: unknown source: %s", i, r.Source)
+		}
+
+		if _, ok := cfg.Credentials[r.DNS.Credentials]; r.DNS.Credentials != "" && !ok {
+			return fmt.Errorf("records[%d].dns.credentials: unknown credentials: %s", i, r.DNS.Credentials)
+		}
+
+		if r.Selector != nil && src.EC2 == nil {
+			return fmt.Errorf("records[%d].selector: source is not an ec2 source: %s", i, r.Source)
+		}
+
+		switch r.Routing.Policy {
+		case routingWeighted:
+			// the canary pool is selected by tags
+			if src.EC2 == nil {
+				return fmt.Errorf("records[%d].routing.canary: source is not an ec2 source: %s", i, r.Source)
+			}
+		case routingLatency, routingGeolocation:
+			// targets are split into record sets by the region they were discovered in
+			if src.EC2 == nil && src.ASG == nil {
+				return fmt.Errorf("records[%d].routing: source is not an ec2 or asg source: %s", i, r.Source)
+			}
+
+			for region := range r.Routing.Locations {
+				if !containsString(src.regions(), region) {
+					return fmt.Errorf("records[%d].routing.locations.%s: not a region of source: %s", i, region, r.Source)
+				}
+			}
+		}
+
+		name := normalizeHost(r.Name)
+		if records[name] {
+			return fmt.Errorf("records[%d]: duplicate name: %s", i, r.Name)
+		}
+		records[name] = true
+	}
+
+	for i, events := range cfg.Events {
+		if events.Region == "" {
+			return fmt.Errorf("events[%d].region: required", i)
+		}
+
+		if events.QueueURL == "" {
+			return fmt.Errorf("events[%d].queue_url: required", i)
+		}
+	}
+
+	return nil
+}
+
+func (src sourceConfig) validate() error {
+	if src.Name == "" {
+		return fmt.Errorf("name: required")
+	}
+
+	var types int
+	for _, set := range []bool{src.EC2 != nil, src.ASG != nil, src.Static != nil, src.File != nil} {
+		if set {
+			types++
+		}
+	}
+
+	switch {
+	case types == 0:
+		return fmt.Errorf("%s: a source type is required", src.Name)
+	case types > 1:
+		return fmt.Errorf("%s: only one source type may be set", src.Name)
+	case src.Static != nil:
+		targets, err := src.Static.targets()
+		if err != nil {
+			return fmt.Errorf("%s: static.%w", src.Name, err)
+		}
+		if len(targets) == 0 {
+			return fmt.Errorf("%s: static: at least one ip addr is required", src.Name)
+		}
+		return nil
+	case src.File != nil:
+		if src.File.Path == "" {
+			return fmt.Errorf("%s: file.path: required", src.Name)
+		}
+		return nil
+	case src.ASG != nil:
+		if err := validateRegions(src.ASG.Region, src.ASG.Regions); err != nil {
+			return fmt.Errorf("%s: asg.%w", src.Name, err)
+		}
+		if len(src.ASG.Names) == 0 {
+			return fmt.Errorf("%s: asg.names: at least one auto scaling group is required", src.Name)
+		}
+		for i, name := range src.ASG.Names {
+			if name == "" {
+				return fmt.Errorf("%s: asg.names[%d]: must not be empty", src.Name, i)
+			}
+		}
+		if err := validateAddress(src.ASG.Address, src.ASG.DeviceIndex); err != nil {
+			return fmt.Errorf("%s: asg.%w", src.Name, err)
+		}
+		if hook := src.ASG.LifecycleHook; hook != nil {
+			if hook.QueueURL == "" {
+				return fmt.Errorf("%s: asg.lifecycle_hook.queue_url: required", src.Name)
+			}
+			if hook.GracePeriod.Duration < 0 {
+				return fmt.Errorf("%s: asg.lifecycle_hook.grace_period: must be positive", src.Name)
+			}
+		}
+		return nil
+	}
+
+	if err := validateRegions(src.EC2.Region, src.EC2.Regions); err != nil {
+		return fmt.Errorf("%s: ec2.%w", src.Name, err)
+	}
+
+	if err := src.EC2.selector().validate(); err != nil {
+		return fmt.Errorf("%s: ec2.%w", src.Name, err)
+	}
+
+	if err := validateAddress(src.EC2.Address, src.EC2.DeviceIndex); err != nil {
+		return fmt.Errorf("%s: ec2.%w", src.Name, err)
+	}
+
+	return nil
+}
+
+// validateRegions ensures an instance source has at least one region
+func validateRegions(region string, regions []string) error {
+	if region == "" && len(regions) == 0 {
+		return fmt.Errorf("region: required")
+	}
+
+	for i, r := range regions {
+		if r == "" {
+			return fmt.Errorf("regions[%d]: must not be empty", i)
+		}
+	}
+
+	return nil
+}
+
+// validateAddress ensures the address mode and device index of an instance
+// source are valid
+func validateAddress(address string, deviceIndex *int64) error {
+	if address != addressPublic && address != addressPrivate && address != addressElastic {
+		return fmt.Errorf("address: must be public, private or eip, got: %s", address)
+	}
+
+	if deviceIndex != nil && *deviceIndex < 0 {
+		return fmt.Errorf("device_index: must be positive")
+	}
+
+	return nil
+}
+
+func (r recordConfig) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name: required")
+	}
+
+	if !hostnameRegexp.MatchString(r.Name) {
+		return fmt.Errorf("name: invalid hostname: %s", r.Name)
+	}
+
+	if r.Source == "" {
+		return fmt.Errorf("%s: source: required", r.Name)
+	}
+
+	if r.Selector != nil {
+		if err := r.Selector.validate(); err != nil {
+			return fmt.Errorf("%s: selector.%w", r.Name, err)
+		}
+	}
+
+	if err := r.HealthCheck.validate(); err != nil {
+		return fmt.Errorf("%s: health_check.%w", r.Name, err)
+	}
+
+	if ttl := aws.Int64Value(r.DNS.TTL); ttl < 1 || ttl > 2147483647 {
+		return fmt.Errorf("%s: dns.ttl: must be between 1 and 2147483647", r.Name)
+	}
+
+	if r.DNS.ZoneID != "" && !zoneIDRegexp.MatchString(r.DNS.ZoneID) {
+		return fmt.Errorf("%s: dns.zone_id: invalid hosted zone id: %s", r.Name, r.DNS.ZoneID)
+	}
+
+	if r.DNS.ZoneType != "" && r.DNS.ZoneType != zoneTypePublic && r.DNS.ZoneType != zoneTypePrivate {
+		return fmt.Errorf("%s: dns.zone_type: must be public or private, got: %s", r.Name, r.DNS.ZoneType)
+	}
+
+	if r.DNS.VPCID != "" && r.DNS.ZoneType == zoneTypePublic {
+		return fmt.Errorf("%s: dns.vpc_id: can only be used with private zones", r.Name)
+	}
+
+	if r.DNS.IPFamily != ipFamilyV4 && r.DNS.IPFamily != ipFamilyV6 && r.DNS.IPFamily != ipFamilyDual {
+		return fmt.Errorf("%s: dns.ip_family: must be v4, v6 or dual, got: %s", r.Name, r.DNS.IPFamily)
+	}
+
+	if err := r.Routing.validate(); err != nil {
+		return fmt.Errorf("%s: routing.%w", r.Name, err)
+	}
+
+	if r.Safety.MinHealthy < 0 {
+		return fmt.Errorf("%s: safety.min_healthy: must be positive", r.Name)
+	}
+
+	if r.Safety.MinHealthyPercent < 0 || r.Safety.MinHealthyPercent > 100 {
+		return fmt.Errorf("%s: safety.min_healthy_percent: must be between 0 and 100", r.Name)
+	}
+
+	return nil
+}
+
+// setDefaults populates any unset optional health check fields
+func (hc *healthCheckConfig) setDefaults() {
+	if hc.Attempts == 0 {
+		hc.Attempts = healthCheckSuccess
+	}
+
+	if hc.Timeout.Duration == 0 {
+		hc.Timeout.Duration = defaultHealthCheckTimeout
+	}
+
+	if hc.Rise == 0 {
+		hc.Rise = defaultHealthCheckRise
+	}
+
+	if hc.Fall == 0 {
+		hc.Fall = defaultHealthCheckFall
+	}
+
+	if hc.Path == "" {
+		hc.Path = defaultHealthCheckPath
+	}
+
+	if hc.Method == "" {
+		hc.Method = http.MethodGet
+	}
+
+	if len(hc.StatusCodes) == 0 {
+		hc.StatusCodes = []statusRange{{Min: http.StatusOK, Max: http.StatusOK}}
+	}
+
+	if len(hc.Schemes) == 0 {
+		hc.Schemes = []string{"http", "https"}
+	}
+
+	if hc.Assert != nil && hc.Assert.BodyLimit == 0 {
+		hc.Assert.BodyLimit = defaultAssertBodyLimit
+	}
+}
+
+func (hc healthCheckConfig) validate() error {
+	if hc.Attempts < 1 {
+		return fmt.Errorf("attempts: must be at least 1")
+	}
+
+	if hc.Timeout.Duration < 0 {
+		return fmt.Errorf("timeout: must be positive")
+	}
+
+	if hc.Rise < 1 {
+		return fmt.Errorf("rise: must be at least 1")
+	}
+
+	if hc.Fall < 1 {
+		return fmt.Errorf("fall: must be at least 1")
+	}
+
+	if !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("path: must start with '/': %s", hc.Path)
+	}
+
+	if !tokenRegexp.MatchString(hc.Method) {
+		return fmt.Errorf("method: invalid http method: %s", hc.Method)
+	}
+
+	for _, r := range hc.StatusCodes {
+		if r.Min < 100 || r.Max > 599 || r.Min > r.Max {
+			return fmt.Errorf("status_codes: invalid range: %d-%d", r.Min, r.Max)
+		}
+	}
+
+	for k := range hc.Headers {
+		if !tokenRegexp.MatchString(k) {
+			return fmt.Errorf("headers: invalid header name: %s", k)
+		}
+	}
+
+	schemes := make(map[string]bool)
+	for _, scheme := range hc.Schemes {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("schemes: must be http or https, got: %s", scheme)
+		}
+
+		if schemes[scheme] {
+			return fmt.Errorf("schemes: duplicate scheme: %s", scheme)
+		}
+		schemes[scheme] = true
+	}
+
+	if hc.Assert != nil {
+		if err := hc.Assert.validate(); err != nil {
+			return fmt.Errorf("assert.%w", err)
+		}
+	}
+
+	return nil
+}
+
+func (a assertConfig) validate() error {
+	if a.BodyLimit < 1 {
+		return fmt.Errorf("body_limit: must be at least 1")
+	}
+
+	for k := range a.Headers {
+		if !tokenRegexp.MatchString(k) {
+			return fmt.Errorf("headers: invalid header name: %s", k)
+		}
+	}
+
+	if a.JSON != nil && a.JSON.Field == "" {
+		return fmt.Errorf("json.field: required")
+	}
+
+	return nil
+}
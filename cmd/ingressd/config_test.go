@@ -0,0 +1,630 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		file    string
+		content string
+		err     string
+	}{
+		"TestYAMLSuccess": {
+			file: "config.yaml",
+			content: `
+poll_interval: 10s
+sources:
+- name: haproxy
+  ec2:
+    region: eu-west-1
+    tag:
+      key: Name
+      value: haproxy
+    match: all
+    tags:
+    - key: Env
+      values: [prod, staging]
+    exclude:
+    - key: Draining
+    address: private
+    device_index: 1
+records:
+- name: syscll.org
+  source: haproxy
+  selector:
+    tags:
+    - key: Role
+      value: ingress
+  health_check:
+    attempts: 1
+    timeout: 5s
+    path: /healthz
+    method: HEAD
+    status_codes: [200, "300-399"]
+    headers:
+      X-Forwarded-Proto: https
+    schemes: [https]
+    assert:
+      body_contains: ok
+      body_regex: "^ok$"
+      headers:
+        Content-Type: text/plain
+      json:
+        field: status
+        value: ok
+  dns:
+    ttl: 30
+    ip_family: dual
+- name: ingress.syscll.org
+  source: haproxy
+`,
+		},
+		"TestJSONSuccess": {
+			file: "config.json",
+			content: `{
+	"poll_interval": "10s",
+	"sources": [
+		{"name": "haproxy", "ec2": {"region": "eu-west-1", "tag": {"key": "Name", "value": "haproxy"}, "match": "all", "tags": [{"key": "Env", "values": ["prod", "staging"]}], "exclude": [{"key": "Draining"}], "address": "private", "device_index": 1}}
+	],
+	"records": [
+		{"name": "syscll.org", "source": "haproxy", "selector": {"tags": [{"key": "Role", "value": "ingress"}]}, "health_check": {"attempts": 1, "timeout": "5s", "path": "/healthz", "method": "HEAD", "status_codes": [200, "300-399"], "headers": {"X-Forwarded-Proto": "https"}, "schemes": ["https"], "assert": {"body_contains": "ok", "body_regex": "^ok$", "headers": {"Content-Type": "text/plain"}, "json": {"field": "status", "value": "ok"}}}, "dns": {"ttl": 30, "ip_family": "dual"}},
+		{"name": "ingress.syscll.org", "source": "haproxy"}
+	]
+}`,
+		},
+		"TestUnknownFieldError": {
+			file: "config.yaml",
+			content: `
+poll_intervals: 10s
+`,
+			err: "error decoding config file",
+		},
+		"TestInvalidStatusCodeError": {
+			file: "config.yaml",
+			content: `
+records:
+- health_check:
+    status_codes: [2xx]
+`,
+			err: "invalid status code: 2xx",
+		},
+		"TestInvalidBodyRegexError": {
+			file: "config.yaml",
+			content: `
+records:
+- health_check:
+    assert:
+      body_regex: "(ok"
+`,
+			err: "invalid regular expression: (ok",
+		},
+		"TestInvalidDurationError": {
+			file:    "config.json",
+			content: `{"poll_interval": "ten seconds"}`,
+			err:     "invalid duration: ten seconds",
+		},
+		"TestUnknownSourceError": {
+			file: "config.yaml",
+			content: `
+sources:
+- name: haproxy
+  ec2:
+    region: eu-west-1
+    tag:
+      key: Name
+records:
+- name: syscll.org
+  source: nginx
+`,
+			err: "invalid config: records[0].source: unknown source: nginx",
+		},
+		"TestZeroTTLError": {
+			file: "config.yaml",
+			content: `
+sources:
+- name: haproxy
+  ec2:
+    region: eu-west-1
+    tag:
+      key: Name
+records:
+- name: syscll.org
+  source: haproxy
+  dns:
+    ttl: 0
+`,
+			err: "invalid config: records[0]: syscll.org: dns.ttl: must be between 1 and 2147483647",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			cfg, err := loadConfig(writeConfigFile(t, test.file, test.content))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing: '%s', got: '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected error: nil, got: %v", err)
+			}
+
+			if cfg.PollInterval.Duration != 10*time.Second {
+				t.Errorf("expected poll interval: 10s, got: %s", cfg.PollInterval)
+			}
+			if cfg.PollTimeout != cfg.PollInterval {
+				t.Errorf("expected poll timeout to default to the poll interval, got: %s", cfg.PollTimeout)
+			}
+			if cfg.Port != defaultPort {
+				t.Errorf("expected port: %d, got: %d", defaultPort, cfg.Port)
+			}
+			if len(cfg.Records) != 2 {
+				t.Fatalf("expected 2 records, got: %d", len(cfg.Records))
+			}
+
+			if sel := cfg.Sources[0].EC2.selector(); sel.Match != tagMatchAll || len(sel.Tags) != 2 || len(sel.Tags[1].values()) != 2 || len(sel.Exclude) != 1 {
+				t.Errorf("unexpected source selector: %+v", sel)
+			}
+			if src := cfg.Sources[0].EC2; src.Address != addressPrivate || src.DeviceIndex == nil || *src.DeviceIndex != 1 {
+				t.Errorf("unexpected source address: %s/%v", src.Address, src.DeviceIndex)
+			}
+			if sel := cfg.Records[0].Selector; sel == nil || len(sel.Tags) != 1 || sel.Tags[0].Key != "Role" {
+				t.Errorf("unexpected record selector: %+v", sel)
+			}
+
+			// explicitly configured record
+			if r := cfg.Records[0]; r.HealthCheck.Attempts != 1 || r.HealthCheck.Timeout.Duration != 5*time.Second || aws.Int64Value(r.DNS.TTL) != 30 || len(r.DNS.rrTypes()) != 2 {
+				t.Errorf("unexpected record config: %+v", r)
+			}
+			hc := cfg.Records[0].HealthCheck
+			if hc.Path != "/healthz" || hc.Method != "HEAD" || hc.Headers["X-Forwarded-Proto"] != "https" || len(hc.Schemes) != 1 {
+				t.Errorf("unexpected health check config: %+v", hc)
+			}
+			if !hc.acceptsStatus(200) || !hc.acceptsStatus(302) || hc.acceptsStatus(201) {
+				t.Errorf("unexpected status codes: %+v", hc.StatusCodes)
+			}
+			if a := hc.Assert; a == nil || a.BodyContains != "ok" || !a.BodyRegex.MatchString("ok") || a.BodyLimit != defaultAssertBodyLimit || a.JSON.Value != "ok" {
+				t.Errorf("unexpected assert config: %+v", a)
+			}
+
+			// defaulted record
+			if r := cfg.Records[1]; r.HealthCheck.Attempts != healthCheckSuccess || r.HealthCheck.Timeout.Duration != defaultHealthCheckTimeout || aws.Int64Value(r.DNS.TTL) != defaultRecordTTL || r.DNS.IPFamily != ipFamilyV4 {
+				t.Errorf("unexpected record defaults: %+v", r)
+			}
+			hc = cfg.Records[1].HealthCheck
+			if hc.Path != "/" || hc.Method != http.MethodGet || len(hc.Schemes) != 2 || !hc.acceptsStatus(200) || hc.acceptsStatus(301) {
+				t.Errorf("unexpected health check defaults: %+v", hc)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	valid := func() config {
+		cfg := config{
+			Sources: []sourceConfig{
+				{
+					Name: "haproxy",
+					EC2: &ec2SourceConfig{
+						Region: "eu-west-1",
+						Tag:    &tagFilter{Key: "Name", Value: "haproxy"},
+					},
+				},
+			},
+			Records: []recordConfig{
+				{Name: "syscll.org", Source: "haproxy"},
+			},
+		}
+		cfg.setDefaults()
+		return cfg
+	}
+
+	testTable := map[string]struct {
+		mutate func(*config)
+		err    string
+	}{
+		"TestSuccess": {
+			mutate: func(*config) {},
+		},
+		"TestInvalidPortError": {
+			mutate: func(cfg *config) { cfg.Port = 70000 },
+			err:    "port: must be between 1 and 65535, got: 70000",
+		},
+		"TestNoSourcesError": {
+			mutate: func(cfg *config) { cfg.Sources = nil },
+			err:    "sources: at least one source is required",
+		},
+		"TestMissingSourceTypeError": {
+			mutate: func(cfg *config) { cfg.Sources[0].EC2 = nil },
+			err:    "sources[0]: haproxy: a source type is required",
+		},
+		"TestMultipleSourceTypesError": {
+			mutate: func(cfg *config) { cfg.Sources[0].Static = &staticSourceConfig{IPs: []string{"192.168.0.1"}} },
+			err:    "sources[0]: haproxy: only one source type may be set",
+		},
+		"TestStaticSourceSuccess": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].Static = &staticSourceConfig{
+					IPs:     []string{"192.168.0.1", "2001:db8::1"},
+					Targets: []targetConfig{{IP: "192.168.0.2", AZ: "rack-a"}},
+				}
+			},
+		},
+		"TestStaticSourceEmptyError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].Static = &staticSourceConfig{}
+			},
+			err: "sources[0]: haproxy: static: at least one ip addr is required",
+		},
+		"TestStaticSourceInvalidIPError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].Static = &staticSourceConfig{Targets: []targetConfig{{IP: "192.168.0"}}}
+			},
+			err: "sources[0]: haproxy: static.targets[0].ip: invalid ip addr: 192.168.0",
+		},
+		"TestFileSourceMissingPathError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].File = &fileSourceConfig{}
+			},
+			err: "sources[0]: haproxy: file.path: required",
+		},
+		"TestASGSourceSuccess": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].ASG = &asgSourceConfig{Region: "eu-west-1", Names: []string{"haproxy"}, Address: addressPrivate}
+			},
+		},
+		"TestASGSourceMissingNamesError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].ASG = &asgSourceConfig{Region: "eu-west-1", Address: addressPublic}
+			},
+			err: "sources[0]: haproxy: asg.names: at least one auto scaling group is required",
+		},
+		"TestASGSourceInvalidAddressError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].ASG = &asgSourceConfig{Region: "eu-west-1", Names: []string{"haproxy"}, Address: "ipv6"}
+			},
+			err: "sources[0]: haproxy: asg.address: must be public, private or eip, got: ipv6",
+		},
+		"TestASGLifecycleHookMissingQueueError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].ASG = &asgSourceConfig{
+					Region:        "eu-west-1",
+					Names:         []string{"haproxy"},
+					Address:       addressPublic,
+					LifecycleHook: &lifecycleHookConfig{},
+				}
+			},
+			err: "sources[0]: haproxy: asg.lifecycle_hook.queue_url: required",
+		},
+		"TestEventsMissingQueueError": {
+			mutate: func(cfg *config) { cfg.Events = []eventsConfig{{Region: "eu-west-1"}} },
+			err:    "events[0].queue_url: required",
+		},
+		"TestLatencyRoutingSuccess": {
+			mutate: func(cfg *config) { cfg.Records[0].Routing.Policy = routingLatency },
+		},
+		"TestWeightedRoutingSourceTypeError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].ASG = &asgSourceConfig{Region: "eu-west-1", Names: []string{"haproxy"}, Address: addressPublic}
+				cfg.Records[0].Routing = routingConfig{
+					Policy: routingWeighted,
+					Canary: &canaryConfig{Selector: tagSelector{Tags: []tagFilter{{Key: "Pool", Value: "canary"}}}, Weight: 10},
+				}
+			},
+			err: "records[0].routing.canary: source is not an ec2 source: haproxy",
+		},
+		"TestCredentialsSuccess": {
+			mutate: func(cfg *config) {
+				cfg.Credentials = map[string]credentialsConfig{
+					"networking": {RoleARN: "arn:aws:iam::123456789012:role/ingressd", ExternalID: "ingressd", SessionName: "ingressd-dns", Duration: duration{time.Hour}},
+				}
+				cfg.AWS.Route53 = "networking"
+				cfg.Records[0].DNS.Credentials = "networking"
+			},
+		},
+		"TestCredentialsRoleARNError": {
+			mutate: func(cfg *config) {
+				cfg.Credentials = map[string]credentialsConfig{"networking": {RoleARN: "ingressd"}}
+			},
+			err: "credentials.networking.role_arn: must be an iam role arn, got: ingressd",
+		},
+		"TestCredentialsExternalIDError": {
+			mutate: func(cfg *config) {
+				cfg.Credentials = map[string]credentialsConfig{"networking": {Profile: "dns", ExternalID: "ingressd"}}
+			},
+			err: "credentials.networking.external_id: requires role_arn",
+		},
+		"TestCredentialsDurationError": {
+			mutate: func(cfg *config) {
+				cfg.Credentials = map[string]credentialsConfig{
+					"networking": {RoleARN: "arn:aws:iam::123456789012:role/ingressd", Duration: duration{time.Minute}},
+				}
+			},
+			err: "credentials.networking.duration: must be between 15m0s and 12h0m0s",
+		},
+		"TestServiceCredentialsError": {
+			mutate: func(cfg *config) {
+				cfg.AWS.EC2 = "workload"
+			},
+			err: "aws.ec2: unknown credentials: workload",
+		},
+		"TestRecordCredentialsError": {
+			mutate: func(cfg *config) {
+				cfg.Records[0].DNS.Credentials = "networking"
+			},
+			err: "records[0].dns.credentials: unknown credentials: networking",
+		},
+		"TestAWSRetrySuccess": {
+			mutate: func(cfg *config) {
+				cfg.AWS.Retry = retryConfig{MaxRetries: aws.Int(0), MinDelay: duration{time.Second}, MaxDelay: duration{time.Second}}
+			},
+		},
+		"TestAWSRetryMaxRetriesError": {
+			mutate: func(cfg *config) {
+				cfg.AWS.Retry.MaxRetries = aws.Int(-1)
+			},
+			err: "aws.retry.max_retries: must be positive",
+		},
+		"TestAWSRetryMaxDelayError": {
+			mutate: func(cfg *config) {
+				cfg.AWS.Retry.MaxDelay.Duration = time.Millisecond
+			},
+			err: "aws.retry.max_delay: must not be less than min_delay",
+		},
+		"TestAWSHTTPTimeoutError": {
+			mutate: func(cfg *config) {
+				cfg.AWS.HTTP.Timeout.Duration = 10 * time.Second
+			},
+			err: "aws.http.timeout: must be longer than the sqs wait time of 20s",
+		},
+		"TestPollTimeoutError": {
+			mutate: func(cfg *config) {
+				cfg.PollTimeout.Duration = -time.Second
+			},
+			err: "poll_timeout: must be positive",
+		},
+		"TestHealthCheckConcurrencyError": {
+			mutate: func(cfg *config) {
+				cfg.HealthCheckConcurrency = -1
+			},
+			err: "health_check_concurrency: must be at least 1",
+		},
+		"TestZoneCacheTTLError": {
+			mutate: func(cfg *config) {
+				cfg.ZoneCacheTTL.Duration = time.Nanosecond
+			},
+			err: "zone_cache_ttl: must be at least 1s, got: 1ns",
+		},
+		"TestRecordTTLError": {
+			mutate: func(cfg *config) {
+				cfg.Records[0].DNS.TTL = aws.Int64(-1)
+			},
+			err: "records[0]: syscll.org: dns.ttl: must be between 1 and 2147483647",
+		},
+		"TestRoutingSourceTypeError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].Static = &staticSourceConfig{IPs: []string{"192.168.0.1"}}
+				cfg.Records[0].Routing.Policy = routingLatency
+			},
+			err: "records[0].routing: source is not an ec2 or asg source: haproxy",
+		},
+		"TestRoutingLocationRegionError": {
+			mutate: func(cfg *config) {
+				cfg.Records[0].Routing = routingConfig{
+					Policy:    routingGeolocation,
+					Locations: map[string]geoLocationConfig{"us-east-1": {Country: "US"}},
+				}
+			},
+			err: "records[0].routing.locations.us-east-1: not a region of source: haproxy",
+		},
+		"TestRecordSelectorSourceTypeError": {
+			mutate: func(cfg *config) {
+				cfg.Sources[0].EC2 = nil
+				cfg.Sources[0].Static = &staticSourceConfig{IPs: []string{"192.168.0.1"}}
+				cfg.Records[0].Selector = &tagSelector{Tags: []tagFilter{{Key: "Role"}}}
+			},
+			err: "records[0].selector: source is not an ec2 source: haproxy",
+		},
+		"TestEmptyRegionsError": {
+			mutate: func(cfg *config) { cfg.Sources[0].EC2.Regions = []string{"us-east-1", ""} },
+			err:    "sources[0]: haproxy: ec2.regions[1]: must not be empty",
+		},
+		"TestMissingRegionError": {
+			mutate: func(cfg *config) { cfg.Sources[0].EC2.Region = "" },
+			err:    "sources[0]: haproxy: ec2.region: required",
+		},
+		"TestMissingTagsError": {
+			mutate: func(cfg *config) { cfg.Sources[0].EC2.Tag = nil },
+			err:    "sources[0]: haproxy: ec2.tags: at least one tag is required",
+		},
+		"TestInvalidTagMatchError": {
+			mutate: func(cfg *config) { cfg.Sources[0].EC2.Match = "one" },
+			err:    "sources[0]: haproxy: ec2.match: must be all or any, got: one",
+		},
+		"TestMissingExcludeKeyError": {
+			mutate: func(cfg *config) { cfg.Sources[0].EC2.Exclude = []tagFilter{{Value: "true"}} },
+			err:    "sources[0]: haproxy: ec2.exclude[0].key: required",
+		},
+		"TestInvalidAddressError": {
+			mutate: func(cfg *config) { cfg.Sources[0].EC2.Address = "elastic" },
+			err:    "sources[0]: haproxy: ec2.address: must be public, private or eip, got: elastic",
+		},
+		"TestInvalidDeviceIndexError": {
+			mutate: func(cfg *config) {
+				index := int64(-1)
+				cfg.Sources[0].EC2.DeviceIndex = &index
+			},
+			err: "sources[0]: haproxy: ec2.device_index: must be positive",
+		},
+		"TestRecordSelectorSuccess": {
+			mutate: func(cfg *config) {
+				cfg.Records[0].Selector = &tagSelector{Tags: []tagFilter{{Key: "Role", Values: []string{"ingress", "edge"}}}}
+			},
+		},
+		"TestInvalidRecordSelectorError": {
+			mutate: func(cfg *config) {
+				cfg.Records[0].Selector = &tagSelector{Tags: []tagFilter{{Key: "Role", Values: []string{""}}}}
+			},
+			err: "records[0]: syscll.org: selector.tags[0].Role: values: must not be empty",
+		},
+		"TestDuplicateSourceError": {
+			mutate: func(cfg *config) { cfg.Sources = append(cfg.Sources, cfg.Sources[0]) },
+			err:    "sources[1]: duplicate name: haproxy",
+		},
+		"TestNoRecordsError": {
+			mutate: func(cfg *config) { cfg.Records = nil },
+			err:    "records: at least one record is required",
+		},
+		"TestInvalidHostnameError": {
+			mutate: func(cfg *config) { cfg.Records[0].Name = "syscll..org" },
+			err:    "records[0]: name: invalid hostname: syscll..org",
+		},
+		"TestDuplicateRecordError": {
+			mutate: func(cfg *config) {
+				r := cfg.Records[0]
+				r.Name = "SYSCLL.org."
+				cfg.Records = append(cfg.Records, r)
+			},
+			err: "records[1]: duplicate name: SYSCLL.org.",
+		},
+		"TestInvalidZoneIDError": {
+			mutate: func(cfg *config) { cfg.Records[0].DNS.ZoneID = "zone 1" },
+			err:    "records[0]: syscll.org: dns.zone_id: invalid hosted zone id: zone 1",
+		},
+		"TestInvalidZoneTypeError": {
+			mutate: func(cfg *config) { cfg.Records[0].DNS.ZoneType = "internal" },
+			err:    "records[0]: syscll.org: dns.zone_type: must be public or private, got: internal",
+		},
+		"TestPublicZoneVPCError": {
+			mutate: func(cfg *config) {
+				cfg.Records[0].DNS.ZoneType = zoneTypePublic
+				cfg.Records[0].DNS.VPCID = "vpc-1"
+			},
+			err: "records[0]: syscll.org: dns.vpc_id: can only be used with private zones",
+		},
+		"TestInvalidIPFamilyError": {
+			mutate: func(cfg *config) { cfg.Records[0].DNS.IPFamily = "v5" },
+			err:    "records[0]: syscll.org: dns.ip_family: must be v4, v6 or dual, got: v5",
+		},
+		"TestInvalidAttemptsError": {
+			mutate: func(cfg *config) { cfg.Records[0].HealthCheck.Attempts = -1 },
+			err:    "records[0]: syscll.org: health_check.attempts: must be at least 1",
+		},
+		"TestInvalidPathError": {
+			mutate: func(cfg *config) { cfg.Records[0].HealthCheck.Path = "healthz" },
+			err:    "records[0]: syscll.org: health_check.path: must start with '/': healthz",
+		},
+		"TestInvalidStatusRangeError": {
+			mutate: func(cfg *config) { cfg.Records[0].HealthCheck.StatusCodes = []statusRange{{Min: 399, Max: 300}} },
+			err:    "records[0]: syscll.org: health_check.status_codes: invalid range: 399-300",
+		},
+		"TestInvalidSchemeError": {
+			mutate: func(cfg *config) { cfg.Records[0].HealthCheck.Schemes = []string{"ftp"} },
+			err:    "records[0]: syscll.org: health_check.schemes: must be http or https, got: ftp",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			cfg := valid()
+			test.mutate(&cfg)
+
+			err := cfg.validate()
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+			}
+			if test.err == "" && err != nil {
+				t.Errorf("expected error: nil, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	for k, v := range map[string]string{
+		envAWSEC2Tag:         "Name:haproxy,Env:prod|staging,!Draining",
+		envAWSRegion:         "eu-west-1",
+		envAWSRoute53Records: "syscll.org, ingress.syscll.org",
+		envPollInterval:      "10s",
+		envPort:              "9090",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	cfg, err := configFromEnv()
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	if len(cfg.Sources) != 1 {
+		t.Fatalf("unexpected sources: %+v", cfg.Sources)
+	}
+	if sel := cfg.Sources[0].EC2.selector(); len(sel.Tags) != 2 || sel.Tags[0].Key != "Name" || len(sel.Tags[1].values()) != 2 || len(sel.Exclude) != 1 {
+		t.Errorf("unexpected source selector: %+v", sel)
+	}
+	if len(cfg.Records) != 2 || cfg.Records[1].Name != "ingress.syscll.org" || cfg.Records[1].Source != envSourceName {
+		t.Errorf("unexpected records: %+v", cfg.Records)
+	}
+	if cfg.PollInterval.Duration != 10*time.Second || cfg.Port != 9090 {
+		t.Errorf("unexpected poll interval/port: %s/%d", cfg.PollInterval, cfg.Port)
+	}
+}
+
+func TestConfigOnly(t *testing.T) {
+	t.Parallel()
+
+	cfg := config{
+		Sources: []sourceConfig{{Name: "a"}, {Name: "b"}},
+		Records: []recordConfig{
+			{Name: "a.syscll.org", Source: "a"},
+			{Name: "b.syscll.org", Source: "b"},
+			{Name: "c.syscll.org", Source: "a"},
+		},
+	}
+
+	limited := cfg.only([]string{"a"})
+	if len(limited.Sources) != 1 || len(limited.Records) != 2 || limited.Records[1].Name != "c.syscll.org" {
+		t.Errorf("unexpected limited config: %+v", limited)
+	}
+	if len(cfg.Sources) != 2 || len(cfg.Records) != 3 {
+		t.Errorf("expected the original config to be unchanged: %+v", cfg)
+	}
+}
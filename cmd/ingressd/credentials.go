@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// name of the default credential chain in logs and health check errors
+	defaultCredentialsName = "default credential chain"
+
+	// default session name of assumed roles, shown in cloudtrail
+	defaultRoleSessionName = "ingressd"
+
+	// assumed role credentials are refreshed this long before they expire
+	credentialsExpiryWindow = time.Minute
+
+	// shortest session duration of an assumed role
+	minRoleDuration = 15 * time.Minute
+
+	// longest session duration of an assumed role
+	maxRoleDuration = 12 * time.Hour
+)
+
+// roleSessionNameRegexp matches a valid sts role session name
+var roleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// credentialsConfig defines how a set of aws credentials is obtained. Without
+// a role, the credentials of the shared config profile, or the default
+// credential chain, are used directly. With a role, they are used to assume it
+type credentialsConfig struct {
+	// shared config profile, default: the default credential chain
+	Profile string `yaml:"profile" json:"profile"`
+
+	// arn of an iam role to assume, e.g: in the account owning the hosted zones
+	RoleARN string `yaml:"role_arn" json:"role_arn"`
+
+	// external id required by the trust policy of the role
+	ExternalID string `yaml:"external_id" json:"external_id"`
+
+	// session name of the assumed role, default: ingressd
+	SessionName string `yaml:"session_name" json:"session_name"`
+
+	// session duration of the assumed role, between 15m and 12h, default: 15m
+	Duration duration `yaml:"duration" json:"duration"`
+}
+
+// serviceCredentials holds the credentials of each aws service, nil for the
+// default credential chain
+type serviceCredentials struct {
+	ec2         *credentials.Credentials
+	autoscaling *credentials.Credentials
+	sqs         *credentials.Credentials
+	route53     *credentials.Credentials
+}
+
+func (c credentialsConfig) validate() error {
+	if c.RoleARN == "" {
+		switch {
+		case c.ExternalID != "":
+			return fmt.Errorf("external_id: requires role_arn")
+		case c.SessionName != "":
+			return fmt.Errorf("session_name: requires role_arn")
+		case c.Duration.Duration != 0:
+			return fmt.Errorf("duration: requires role_arn")
+		}
+		return nil
+	}
+
+	if !strings.HasPrefix(c.RoleARN, "arn:") || !strings.Contains(c.RoleARN, ":role/") {
+		return fmt.Errorf("role_arn: must be an iam role arn, got: %s", c.RoleARN)
+	}
+
+	if c.SessionName != "" && !roleSessionNameRegexp.MatchString(c.SessionName) {
+		return fmt.Errorf("session_name: must be 2 to 64 letters, digits or any of +=,.@_-, got: %s", c.SessionName)
+	}
+
+	if d := c.Duration.Duration; d != 0 && (d < minRoleDuration || d > maxRoleDuration) {
+		return fmt.Errorf("duration: must be between %s and %s", minRoleDuration, maxRoleDuration)
+	}
+
+	return nil
+}
+
+// newAWSCredentials creates the credentials of each named credentials config.
+// Each is created once and shared by every client using it, so that assumed
+// role credentials are cached and refreshed before they expire
+func newAWSCredentials(sess *session.Session, configs map[string]credentialsConfig) (map[string]*credentials.Credentials, error) {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	creds := make(map[string]*credentials.Credentials, len(configs))
+	for _, name := range names {
+		c, err := newCredentials(sess, configs[name])
+		if err != nil {
+			return nil, fmt.Errorf("error creating credentials: %s: %w", name, err)
+		}
+		creds[name] = c
+	}
+
+	return creds, nil
+}
+
+// newCredentials creates the credentials of a credentials config, nil if it
+// uses the default credential chain without assuming a role
+func newCredentials(sess *session.Session, cfg credentialsConfig) (*credentials.Credentials, error) {
+	if cfg.Profile == "" && cfg.RoleARN == "" {
+		return nil, nil
+	}
+
+	// sts is called in the region of the global endpoint
+	sess = sess.Copy(&aws.Config{Region: aws.String(defaultRoute53Region)})
+
+	// a profile needs its own session, keeping the shared retry policy and
+	// http transport, but not the default credential chain
+	if cfg.Profile != "" {
+		c := *sess.Config
+		c.Credentials = nil
+
+		var err error
+		sess, err = session.NewSessionWithOptions(session.Options{
+			Config:            c,
+			Profile:           cfg.Profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating session: %w", err)
+		}
+	}
+
+	if cfg.RoleARN == "" {
+		return sess.Config.Credentials, nil
+	}
+
+	return stscreds.NewCredentials(sess, cfg.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = cfg.SessionName
+		p.ExpiryWindow = credentialsExpiryWindow
+		if cfg.ExternalID != "" {
+			p.ExternalID = aws.String(cfg.ExternalID)
+		}
+		if cfg.Duration.Duration != 0 {
+			p.Duration = cfg.Duration.Duration
+		}
+	}), nil
+}
+
+// credentialsHealth checks that each set of aws credentials in use can be
+// retrieved, so that missing, expired or unassumable credentials are reported
+// by the health check instead of only failing requests
+type credentialsHealth struct {
+	// credentials in use, by name, with the default credential chain unnamed
+	creds map[string]*credentials.Credentials
+
+	mu sync.Mutex
+
+	// error of the last retrieval of each set of credentials, by name
+	errs map[string]error
+}
+
+// newCredentialsHealth creates a health check of the given credentials
+func newCredentialsHealth(creds map[string]*credentials.Credentials) *credentialsHealth {
+	return &credentialsHealth{
+		creds: creds,
+		errs:  make(map[string]error),
+	}
+}
+
+// check retrieves every set of credentials, refreshing any that have expired
+func (h *credentialsHealth) check(ctx context.Context) {
+	for name, c := range h.creds {
+		// nil credentials are resolved by each client's session
+		if c == nil {
+			continue
+		}
+
+		if name == "" {
+			name = defaultCredentialsName
+		}
+
+		_, err := c.GetWithContext(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("credentials", name).Msg("error retrieving aws credentials")
+			err = fmt.Errorf("error retrieving credentials: %s: %w", name, err)
+		}
+
+		h.mu.Lock()
+		h.errs[name] = err
+		h.mu.Unlock()
+	}
+}
+
+// err returns the error of the first set of credentials, by name, that could
+// not be retrieved on the last check
+func (h *credentialsHealth) err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	names := make([]string, 0, len(h.errs))
+	for name := range h.errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := h.errs[name]; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestNewAWSCredentials(t *testing.T) {
+	t.Parallel()
+
+	cfg := config{
+		Credentials: map[string]credentialsConfig{
+			"default":    {},
+			"networking": {RoleARN: "arn:aws:iam::123456789012:role/ingressd", ExternalID: "ingressd"},
+		},
+	}
+	cfg.setDefaults()
+
+	if name := cfg.Credentials["networking"].SessionName; name != defaultRoleSessionName {
+		t.Errorf("expected session name: %s, got: %s", defaultRoleSessionName, name)
+	}
+	if name := cfg.Credentials["default"].SessionName; name != "" {
+		t.Errorf("expected no session name without a role, got: %s", name)
+	}
+
+	sess, err := newAWSSession(cfg.AWS)
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	creds, err := newAWSCredentials(sess, cfg.Credentials)
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	// the default credential chain is left to each client's session
+	if c, ok := creds["default"]; !ok || c != nil {
+		t.Errorf("expected nil credentials for the default credential chain, got: %v", c)
+	}
+	if creds["networking"] == nil {
+		t.Errorf("expected assumed role credentials, got: nil")
+	}
+}
+
+// mockCredentialsProvider implements credentials.Provider
+type mockCredentialsProvider struct {
+	err error
+}
+
+func (p *mockCredentialsProvider) Retrieve() (credentials.Value, error) {
+	return credentials.Value{}, p.err
+}
+
+func (p *mockCredentialsProvider) IsExpired() bool {
+	return true
+}
+
+func TestCredentialsHealth(t *testing.T) {
+	t.Parallel()
+
+	workload := &mockCredentialsProvider{}
+	networking := &mockCredentialsProvider{err: errors.New("access denied")}
+
+	h := newCredentialsHealth(map[string]*credentials.Credentials{
+		"":           nil,
+		"workload":   credentials.NewCredentials(workload),
+		"networking": credentials.NewCredentials(networking),
+	})
+
+	if err := h.err(); err != nil {
+		t.Errorf("expected no error before the first check, got: %v", err)
+	}
+
+	h.check(context.Background())
+	if err := h.err(); err == nil || err.Error() != "error retrieving credentials: networking: access denied" {
+		t.Errorf("expected error: 'error retrieving credentials: networking: access denied', got: '%v'", err)
+	}
+
+	// credentials that can be retrieved again clear the error
+	networking.err = nil
+	h.check(context.Background())
+	if err := h.err(); err != nil {
+		t.Errorf("expected error: nil, got: %v", err)
+	}
+}
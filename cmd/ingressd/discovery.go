@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/rs/zerolog/log"
+)
+
+// target is a single discovered ingress ip addr, along with metadata about
+// where it was discovered
+type target struct {
+	// ip addr to health check and publish
+	IP net.IP
+
+	// id of the instance the ip addr belongs to, if any
+	InstanceID string
+
+	// availability zone of the target, if known
+	AZ string
+
+	// aws region the target was discovered in, if any
+	Region string
+
+	// labels of the target, e.g: the tags of an ec2 instance
+	Labels map[string]string
+}
+
+// Discoverer finds the current targets of a source
+type Discoverer interface {
+	Discover(ctx context.Context) ([]target, error)
+}
+
+// watcher is implemented by discoverers that notice when their targets change
+type watcher interface {
+	// watch calls changed whenever the targets change, until stop is closed
+	watch(stop <-chan struct{}, changed func())
+}
+
+// newDiscoverer creates the discoverer of a configured source, using the aws
+// manager of each of its regions. If a record has its own selector, it
+// replaces the selector of the source
+func newDiscoverer(src sourceConfig, managers map[string]awsManager, sel *tagSelector) (Discoverer, error) {
+	if sel != nil && src.EC2 == nil {
+		return nil, fmt.Errorf("selector: source is not an ec2 source: %s", src.Name)
+	}
+
+	switch {
+	case src.EC2 != nil:
+		s := src.EC2.selector()
+		if sel != nil {
+			s = *sel
+		}
+		return newRegionalDiscoverer(src.regions(), func(region string) Discoverer {
+			return newEC2Discoverer(managers[region].ec2, *src.EC2, s)
+		}), nil
+	case src.ASG != nil:
+		return newRegionalDiscoverer(src.regions(), func(region string) Discoverer {
+			mgr := managers[region]
+			return newASGDiscoverer(mgr.autoscaling, mgr.ec2, *src.ASG)
+		}), nil
+	case src.Static != nil:
+		targets, err := src.Static.targets()
+		if err != nil {
+			return nil, err
+		}
+		return staticDiscoverer(targets), nil
+	case src.File != nil:
+		return newFileDiscoverer(src.File.Path), nil
+	default:
+		return nil, fmt.Errorf("unknown source type: %s", src.Name)
+	}
+}
+
+// staticDiscoverer always discovers the same targets
+type staticDiscoverer []target
+
+// Discover returns the static targets
+func (d staticDiscoverer) Discover(context.Context) ([]target, error) {
+	return d, nil
+}
+
+// regionalDiscoverer discovers the targets of a source in each of its regions
+// concurrently, labelling each target with its region. If a region fails, the
+// targets it last discovered are used instead, so that one region's outage
+// does not remove its targets from records
+type regionalDiscoverer struct {
+	regions []string
+
+	// discoverer of each region, by region
+	discoverers map[string]Discoverer
+
+	mu sync.Mutex
+
+	// targets last discovered in each region, by region
+	last map[string][]target
+}
+
+// newRegionalDiscoverer creates a discoverer for each region
+func newRegionalDiscoverer(regions []string, discoverer func(region string) Discoverer) *regionalDiscoverer {
+	d := &regionalDiscoverer{
+		regions:     regions,
+		discoverers: make(map[string]Discoverer, len(regions)),
+		last:        make(map[string][]target, len(regions)),
+	}
+
+	for _, region := range regions {
+		d.discoverers[region] = discoverer(region)
+	}
+
+	return d
+}
+
+// Discover queries every region concurrently, returning an error only if
+// every region fails
+func (d *regionalDiscoverer) Discover(ctx context.Context) ([]target, error) {
+	results := make([][]target, len(d.regions))
+	errs := make([]error, len(d.regions))
+
+	var wg sync.WaitGroup
+	for i, region := range d.regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+
+			targets, err := d.discoverers[region].Discover(ctx)
+			for j := range targets {
+				targets[j].Region = region
+			}
+			results[i], errs[i] = targets, err
+		}(i, region)
+	}
+
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var targets []target
+	var failed int
+	for i, region := range d.regions {
+		if errs[i] == nil {
+			d.last[region] = results[i]
+			targets = append(targets, results[i]...)
+			continue
+		}
+
+		failed++
+		if len(d.regions) > 1 {
+			log.Error().Err(errs[i]).Str("region", region).Msgf("error discovering targets, using %d previously discovered ip addrs", len(d.last[region]))
+			targets = append(targets, d.last[region]...)
+		}
+	}
+
+	if failed > 0 && failed == len(d.regions) {
+		if failed == 1 {
+			return nil, errs[0]
+		}
+		return nil, fmt.Errorf("error discovering targets in every region: %s: %w", d.regions[0], errs[0])
+	}
+
+	return targets, nil
+}
+
+// discovery holds the discoverer of each source and the route53 manager of
+// each record, along with the discoverers of records with their own selector.
+// Discoverers are created once, so that sources such as files can keep state
+// between polls
+type discovery struct {
+	// aws service manager of the hosted zone of each record, by record name
+	route53 map[string]awsManager
+
+	// hosted zone cache of each set of route53 credentials used to look up
+	// the hosted zone of a record
+	zones []*zoneCache
+
+	// health of the aws credentials in use
+	credentials *credentialsHealth
+
+	// discoverer of each source, by source name
+	sources map[string]Discoverer
+
+	// discoverer of each record with its own selector, by record name
+	records map[string]Discoverer
+
+	// discoverer of the canary pool of each weighted record, by record name
+	canaries map[string]Discoverer
+
+	// drainer of each auto scaling group source with a lifecycle hook
+	drainers map[string]*drainer
+
+	// instances being drained, excluded from every record
+	draining *drainSet
+
+	// watchers of each queue of ec2 instance state-change notifications
+	events []*eventWatcher
+
+	// aws regions of each source that discovers ec2 instances, by source name
+	regions map[string][]string
+
+	// names of the records of each source, by source name
+	sourceRecords map[string][]string
+
+	mu sync.Mutex
+
+	// ids of the instances last discovered by each source, by source name
+	instances map[string]map[string]bool
+}
+
+// newDiscovery creates the aws managers and discoverers of a config. Every
+// manager shares a single aws session. A manager is created for each region,
+// and for the hosted zones of each set of route53 credentials, each with its
+// own hosted zone cache
+func newDiscovery(cfg config) (*discovery, error) {
+	sess, err := newAWSSession(cfg.AWS)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := newAWSCredentials(sess, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	services := serviceCredentials{
+		ec2:         creds[cfg.AWS.EC2],
+		autoscaling: creds[cfg.AWS.AutoScaling],
+		sqs:         creds[cfg.AWS.SQS],
+		route53:     creds[cfg.AWS.Route53],
+	}
+
+	d := &discovery{
+		route53:       make(map[string]awsManager),
+		sources:       make(map[string]Discoverer),
+		records:       make(map[string]Discoverer),
+		canaries:      make(map[string]Discoverer),
+		drainers:      make(map[string]*drainer),
+		draining:      newDrainSet(),
+		regions:       make(map[string][]string),
+		sourceRecords: make(map[string][]string),
+		instances:     make(map[string]map[string]bool),
+	}
+	d.draining.records = d.instanceRecords
+
+	// aws manager of each region, by region
+	regional := make(map[string]awsManager)
+	manager := func(region string) awsManager {
+		mgr, ok := regional[region]
+		if !ok {
+			mgr = newAWSManager(sess, region, services)
+			regional[region] = mgr
+		}
+		return mgr
+	}
+
+	// route53 manager of each named credentials, by name. route53 is a global
+	// service, so the region serving its api is used
+	dns := make(map[string]awsManager)
+	zoneManager := func(name string) awsManager {
+		mgr, ok := dns[name]
+		if !ok {
+			zoneServices := services
+			zoneServices.route53 = creds[name]
+
+			mgr = newAWSManager(sess, defaultRoute53Region, zoneServices)
+			mgr.zones = newZoneCache(mgr.listRoute53HostedZones, cfg.ZoneCacheTTL.Duration)
+			dns[name] = mgr
+		}
+		return mgr
+	}
+
+	// credentials used by a service or record, by name. Only these are health
+	// checked, so an unused default credential chain is never reported
+	used := make(map[string]*credentials.Credentials)
+	use := func(name string) {
+		if name == "" {
+			used[name] = sess.Config.Credentials
+			return
+		}
+		used[name] = creds[name]
+	}
+
+	sources := make(map[string]sourceConfig)
+	for _, src := range cfg.Sources {
+		sources[src.Name] = src
+
+		switch {
+		case src.EC2 != nil:
+			use(cfg.AWS.EC2)
+		case src.ASG != nil:
+			use(cfg.AWS.EC2)
+			use(cfg.AWS.AutoScaling)
+			if src.ASG.LifecycleHook != nil {
+				use(cfg.AWS.SQS)
+			}
+		}
+
+		for _, region := range src.regions() {
+			manager(region)
+		}
+
+		// lifecycle hook queues use the primary region
+		mgr := manager(src.region())
+
+		disc, err := newDiscoverer(src, regional, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating source: %s: %w", src.Name, err)
+		}
+		d.sources[src.Name] = disc
+
+		if src.EC2 != nil || src.ASG != nil {
+			d.regions[src.Name] = src.regions()
+		}
+
+		if src.ASG != nil && src.ASG.LifecycleHook != nil {
+			d.drainers[src.Name] = newDrainer(mgr.sqs, mgr.autoscaling, *src.ASG, maxRecordTTL(cfg.Records, src.Name), d.draining)
+		}
+	}
+
+	// names of the credentials whose hosted zones are looked up by a record
+	lookups := make(map[string]bool)
+
+	for _, record := range cfg.Records {
+		name := cfg.route53Credentials(record)
+		mgr := zoneManager(name)
+		d.route53[record.Name] = mgr
+		d.sourceRecords[record.Source] = append(d.sourceRecords[record.Source], record.Name)
+		use(name)
+
+		// only caches that records look up hosted zones from are refreshed
+		if record.DNS.ZoneID == "" && !lookups[name] {
+			lookups[name] = true
+			d.zones = append(d.zones, mgr.zones)
+		}
+
+		if record.Selector != nil {
+			disc, err := newDiscoverer(sources[record.Source], regional, record.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("error creating record source: %s: %w", record.Name, err)
+			}
+			d.records[record.Name] = disc
+		}
+
+		if canary := record.Routing.Canary; canary != nil {
+			disc, err := newDiscoverer(sources[record.Source], regional, &canary.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("error creating record canary source: %s: %w", record.Name, err)
+			}
+			d.canaries[record.Name] = disc
+		}
+	}
+
+	for _, events := range cfg.Events {
+		d.events = append(d.events, newEventWatcher(manager(events.Region).sqs, events.QueueURL))
+		use(cfg.AWS.SQS)
+	}
+
+	d.credentials = newCredentialsHealth(used)
+
+	return d, nil
+}
+
+// watch starts watching every source that supports it, along with any
+// lifecycle hook and state-change notification queues, adding the sources
+// whose targets may have changed to changes, until stop is closed
+func (d *discovery) watch(stop <-chan struct{}, changes *changeSet) {
+	for name, disc := range d.sources {
+		if w, ok := disc.(watcher); ok {
+			log.Info().Str("source", name).Msg("watching source for changes")
+
+			name := name
+			go w.watch(stop, func() { changes.notify(name) })
+		}
+	}
+
+	for name, dr := range d.drainers {
+		log.Info().Str("source", name).Str("queue", dr.queueURL).Msg("receiving lifecycle hook notifications")
+		go dr.watch(stop, func(id string) { changes.notify(d.instanceSources(id)...) })
+	}
+
+	for _, w := range d.events {
+		log.Info().Str("queue", w.queueURL).Msg("receiving state-change notifications")
+		go w.watch(stop, func(ev stateChangeEvent) { changes.notify(d.affectedSources(ev)...) })
+	}
+}
+
+// observe records the instances discovered by a source, replacing those of
+// the previous poll
+func (d *discovery) observe(source string, targets []target) {
+	ids := make(map[string]bool)
+	for _, t := range targets {
+		if t.InstanceID != "" {
+			ids[t.InstanceID] = true
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.instances[source] = ids
+}
+
+// instanceSources returns the names of the sources that last discovered an instance
+func (d *discovery) instanceSources(id string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var sources []string
+	for name, ids := range d.instances {
+		if ids[id] {
+			sources = append(sources, name)
+		}
+	}
+
+	return sources
+}
+
+// instanceRecords returns the names of the records of the sources that last
+// discovered an instance
+func (d *discovery) instanceRecords(id string) []string {
+	var records []string
+	for _, source := range d.instanceSources(id) {
+		records = append(records, d.sourceRecords[source]...)
+	}
+
+	return records
+}
+
+// affectedSources returns the names of the sources whose targets may change
+// due to a state-change event. An instance that starts running may be
+// discovered by any source in its region, otherwise only sources that last
+// discovered it are affected
+func (d *discovery) affectedSources(ev stateChangeEvent) []string {
+	sources := d.instanceSources(ev.Detail.InstanceID)
+
+	if ev.running() {
+		for name, regions := range d.regions {
+			if containsString(regions, ev.Region) {
+				sources = append(sources, name)
+			}
+		}
+	}
+
+	return sources
+}
+
+// maxRecordTTL returns the longest ttl of the records using a source
+func maxRecordTTL(records []recordConfig, source string) int64 {
+	var ttl int64
+	for _, record := range records {
+		if record.Source == source && aws.Int64Value(record.DNS.TTL) > ttl {
+			ttl = aws.Int64Value(record.DNS.TTL)
+		}
+	}
+
+	return ttl
+}
+
+// targetIPs returns the ip addrs of a set of targets
+func targetIPs(targets []target) []net.IP {
+	ips := make([]net.IP, 0, len(targets))
+	for _, t := range targets {
+		ips = append(ips, t.IP)
+	}
+
+	return ips
+}
+
+// filterTargetsByRRType returns the targets published as the given record type
+func filterTargetsByRRType(targets []target, rrType string) []target {
+	var filtered []target
+	for _, t := range targets {
+		if ipRRType(t.IP) == rrType {
+			filtered = append(filtered, t)
+		}
+	}
+
+	return filtered
+}
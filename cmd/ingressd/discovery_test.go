@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestFilterTargetsByRRType(t *testing.T) {
+	t.Parallel()
+
+	targets := []target{
+		{IP: net.ParseIP("192.168.0.1")},
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("::ffff:192.168.0.2")},
+	}
+
+	if v4 := targetIPs(filterTargetsByRRType(targets, route53.RRTypeA)); len(v4) != 2 || !v4[1].Equal(net.ParseIP("192.168.0.2")) {
+		t.Errorf("unexpected A ip addrs: %v", v4)
+	}
+
+	v6 := targetIPs(filterTargetsByRRType(targets, route53.RRTypeAaaa))
+	if len(v6) != 1 || v6[0].String() != "2001:db8::1" {
+		t.Errorf("unexpected AAAA ip addrs: %v", v6)
+	}
+
+	change := newRoute53Change("syscll.org", route53.RRTypeAaaa, recordRouting{}, v6, defaultRecordTTL)
+	if rrs := change.ResourceRecordSet; aws.StringValue(rrs.Type) != route53.RRTypeAaaa || aws.StringValue(rrs.ResourceRecords[0].Value) != "2001:db8::1" {
+		t.Errorf("unexpected AAAA change: %v", change)
+	}
+}
+
+func TestNewDiscoverer(t *testing.T) {
+	t.Parallel()
+
+	src := sourceConfig{
+		Name: "haproxy",
+		EC2: &ec2SourceConfig{
+			Region:  "eu-west-1",
+			Address: addressPrivate,
+			Tag:     &tagFilter{Key: "Name", Value: "haproxy"},
+		},
+	}
+
+	// ec2 discoverer of the only region of a source
+	regional := func(d Discoverer) *ec2Discoverer {
+		r, ok := d.(*regionalDiscoverer)
+		if !ok || len(r.regions) != 1 {
+			t.Fatalf("unexpected discoverer: %+v", d)
+		}
+		return r.discoverers[r.regions[0]].(*ec2Discoverer)
+	}
+
+	d, err := newDiscoverer(src, nil, nil)
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if ec2 := regional(d); ec2.address != addressPrivate || ec2.sel.Tags[0].Key != "Name" {
+		t.Errorf("unexpected discoverer: %+v", ec2)
+	}
+
+	// a record selector replaces that of the source
+	d, err = newDiscoverer(src, nil, &tagSelector{Tags: []tagFilter{{Key: "Role"}}})
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if ec2 := regional(d); len(ec2.sel.Tags) != 1 || ec2.sel.Tags[0].Key != "Role" {
+		t.Errorf("unexpected record selector: %+v", ec2.sel)
+	}
+
+	if _, err := newDiscoverer(sourceConfig{Name: "none"}, nil, nil); err == nil || err.Error() != "unknown source type: none" {
+		t.Errorf("expected unknown source type error, got: %v", err)
+	}
+}
+
+func TestStaticDiscoverer(t *testing.T) {
+	t.Parallel()
+
+	src := sourceConfig{
+		Name: "onprem",
+		Static: &staticSourceConfig{
+			IPs:     []string{"192.168.0.1"},
+			Targets: []targetConfig{{IP: "2001:db8::1", AZ: "rack-a", Labels: map[string]string{"host": "lb-1"}}},
+		},
+	}
+
+	d, err := newDiscoverer(src, nil, nil)
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	if len(targets) != 2 || targets[0].IP.String() != "192.168.0.1" || targets[1].AZ != "rack-a" || targets[1].Labels["host"] != "lb-1" {
+		t.Errorf("unexpected targets: %+v", targets)
+	}
+
+	if _, err := newDiscoverer(src, nil, &tagSelector{Tags: []tagFilter{{Key: "Role"}}}); err == nil {
+		t.Errorf("expected selector error for static source, got: nil")
+	}
+}
+
+func TestNewDiscovery(t *testing.T) {
+	t.Parallel()
+
+	cfg := config{
+		Sources: []sourceConfig{
+			{Name: "onprem", Static: &staticSourceConfig{IPs: []string{"192.168.0.1"}}},
+			{Name: "haproxy", EC2: &ec2SourceConfig{Region: "eu-west-1", Regions: []string{"us-east-1"}, Tag: &tagFilter{Key: "Name"}}},
+		},
+		Records: []recordConfig{
+			{Name: "syscll.org", Source: "onprem"},
+			{Name: "ingress.syscll.org", Source: "haproxy", Selector: &tagSelector{Tags: []tagFilter{{Key: "Role"}}}},
+			{Name: "internal.syscll.org", Source: "haproxy", DNS: dnsConfig{Credentials: "networking"}},
+			{Name: "legacy.syscll.org", Source: "onprem", DNS: dnsConfig{Credentials: "legacy", ZoneID: "Z1"}},
+		},
+		Credentials: map[string]credentialsConfig{
+			"networking": {RoleARN: "arn:aws:iam::123456789012:role/ingressd", ExternalID: "ingressd"},
+			"legacy":     {RoleARN: "arn:aws:iam::210987654321:role/ingressd"},
+		},
+	}
+	cfg.setDefaults()
+
+	d, err := newDiscovery(cfg)
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	if len(d.sources) != 2 || len(d.route53) != 4 {
+		t.Errorf("expected a discoverer per source and manager per record, got: %d/%d", len(d.sources), len(d.route53))
+	}
+	if _, ok := d.records["ingress.syscll.org"]; !ok || len(d.records) != 1 {
+		t.Errorf("expected a discoverer for the record with a selector, got: %v", d.records)
+	}
+	if mgr := d.route53["syscll.org"]; mgr.region != defaultRoute53Region || mgr.zones == nil || mgr.zones != d.route53["ingress.syscll.org"].zones {
+		t.Errorf("expected records with the same credentials to share a hosted zone cache, got: %+v", mgr)
+	}
+	if mgr := d.route53["internal.syscll.org"]; len(d.zones) != 2 || mgr.zones == d.route53["syscll.org"].zones {
+		t.Errorf("expected a hosted zone cache per set of credentials, got: %d", len(d.zones))
+	}
+	for _, zones := range d.zones {
+		if zones == d.route53["legacy.syscll.org"].zones {
+			t.Errorf("expected no refreshed hosted zone cache for credentials only used with a pinned zone id")
+		}
+	}
+	if r, ok := d.sources["haproxy"].(*regionalDiscoverer); !ok || len(r.discoverers) != 2 {
+		t.Errorf("expected a discoverer for each region, got: %+v", d.sources["haproxy"])
+	}
+}
+
+func TestDiscoveryAffectedSources(t *testing.T) {
+	t.Parallel()
+
+	cfg := config{
+		Sources: []sourceConfig{
+			{Name: "onprem", Static: &staticSourceConfig{IPs: []string{"192.168.0.1"}}},
+			{Name: "eu", EC2: &ec2SourceConfig{Region: "eu-west-1", Tag: &tagFilter{Key: "Name"}}},
+			{Name: "us", EC2: &ec2SourceConfig{Region: "us-east-1", Tag: &tagFilter{Key: "Name"}}},
+		},
+		Records: []recordConfig{
+			{Name: "syscll.org", Source: "eu"},
+		},
+	}
+	cfg.setDefaults()
+
+	d, err := newDiscovery(cfg)
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	d.observe("us", []target{{InstanceID: "i-1"}})
+	d.observe("eu", []target{{InstanceID: "i-3"}})
+
+	// a draining instance may be published by the records of the sources that discovered it
+	if records := d.instanceRecords("i-3"); fmt.Sprint(records) != "[syscll.org]" {
+		t.Errorf("expected instance records: [syscll.org], got: %v", records)
+	}
+
+	var ev stateChangeEvent
+	ev.Region = "eu-west-1"
+	ev.Detail.InstanceID = "i-1"
+
+	// an instance that stops only affects the sources that discovered it
+	ev.Detail.State = "stopping"
+	if sources := d.affectedSources(ev); fmt.Sprint(sources) != "[us]" {
+		t.Errorf("expected affected sources: [us], got: %v", sources)
+	}
+
+	// an instance that starts running may be discovered by any source in its region
+	ev.Detail.State = "running"
+	changes := newChangeSet()
+	changes.notify(d.affectedSources(ev)...)
+	if sources := changes.take(); fmt.Sprint(sources) != "[eu us]" {
+		t.Errorf("expected affected sources: [eu us], got: %v", sources)
+	}
+
+	// an unknown instance that stops affects no sources
+	ev.Detail.InstanceID, ev.Detail.State = "i-2", "stopped"
+	if sources := d.affectedSources(ev); len(sources) != 0 {
+		t.Errorf("expected no affected sources, got: %v", sources)
+	}
+}
+
+// discovererFunc adapts a function to a Discoverer
+type discovererFunc func(context.Context) ([]target, error)
+
+func (f discovererFunc) Discover(ctx context.Context) ([]target, error) {
+	return f(ctx)
+}
+
+func TestRegionalDiscoverer(t *testing.T) {
+	t.Parallel()
+
+	// whether each region fails, by region
+	var mu sync.Mutex
+	failing := make(map[string]bool)
+
+	d := newRegionalDiscoverer([]string{"eu-west-1", "us-east-1"}, func(region string) Discoverer {
+		return discovererFunc(func(context.Context) ([]target, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failing[region] {
+				return nil, fmt.Errorf("aws error")
+			}
+			return []target{{InstanceID: region}}, nil
+		})
+	})
+
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if len(targets) != 2 || targets[0].Region != "eu-west-1" || targets[1].Region != "us-east-1" {
+		t.Errorf("expected a target labelled with each region, got: %+v", targets)
+	}
+
+	// a failing region keeps its previously discovered targets
+	mu.Lock()
+	failing["us-east-1"] = true
+	mu.Unlock()
+
+	targets, err = d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if len(targets) != 2 || targets[1].InstanceID != "us-east-1" {
+		t.Errorf("expected previous targets of the failing region, got: %+v", targets)
+	}
+
+	// an error is only returned when every region fails
+	mu.Lock()
+	failing["eu-west-1"] = true
+	mu.Unlock()
+
+	expected := "error discovering targets in every region: eu-west-1: aws error"
+	if _, err := d.Discover(context.Background()); err == nil || err.Error() != expected {
+		t.Errorf("expected error: '%s', got: '%v'", expected, err)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// lifecycle transition of an instance being terminated by an auto scaling group
+	lifecycleTransitionTerminating = "autoscaling:EC2_INSTANCE_TERMINATING"
+
+	// result of a completed lifecycle action allowing termination to continue
+	lifecycleActionContinue = "CONTINUE"
+
+	// how long a drained instance stays excluded after its lifecycle action is
+	// completed, covering the time it takes to terminate
+	drainRetention = 10 * time.Minute
+)
+
+// lifecycleMessage is the notification sent to sqs by an auto scaling lifecycle hook
+type lifecycleMessage struct {
+	// set on test notifications sent when a hook is created
+	Event string `json:"Event"`
+
+	LifecycleTransition  string `json:"LifecycleTransition"`
+	LifecycleHookName    string `json:"LifecycleHookName"`
+	LifecycleActionToken string `json:"LifecycleActionToken"`
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+}
+
+// drainSet is the set of instances being drained, which are excluded from
+// every record until they have terminated
+type drainSet struct {
+	mu  sync.Mutex
+	ids map[string]*drainState
+
+	// number of instances added so far, ordering additions against polls
+	seq uint64
+
+	// returns the names of the records that may publish the ip addrs of an
+	// instance, none if nil
+	records func(id string) []string
+}
+
+// drainState tracks the removal of a draining instance from its records
+type drainState struct {
+	// value of the set's seq once the instance was added
+	seq uint64
+
+	// names of the records that may still publish the instance's ip addrs
+	pending map[string]bool
+
+	// closed once the instance has been removed from every record
+	removed chan struct{}
+}
+
+func newDrainSet() *drainSet {
+	return &drainSet{ids: make(map[string]*drainState)}
+}
+
+// add marks an instance as draining, reporting whether it was not already
+func (s *drainSet) add(id string) bool {
+	var records []string
+	if s.records != nil {
+		records = s.records(id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+
+	s.seq++
+	state := &drainState{
+		seq:     s.seq,
+		pending: make(map[string]bool, len(records)),
+		removed: make(chan struct{}),
+	}
+	for _, record := range records {
+		state.pending[record] = true
+	}
+	if len(state.pending) == 0 {
+		close(state.removed)
+	}
+	s.ids[id] = state
+
+	return true
+}
+
+func (s *drainSet) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ids, id)
+}
+
+// removed returns a channel that is closed once an instance has been removed
+// from every record, nil if it is not draining
+func (s *drainSet) removed(id string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.ids[id]; ok {
+		return state.removed
+	}
+
+	return nil
+}
+
+// mark returns a marker of the instances added so far. Targets filtered after
+// taking a marker exclude every instance added before it
+func (s *drainSet) mark() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seq
+}
+
+// applied records that every record set of a record was brought up to date by
+// a poll that took the given marker before filtering its targets, so the
+// record no longer publishes any instance added before the marker
+func (s *drainSet) applied(record string, mark uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, state := range s.ids {
+		if state.seq > mark || !state.pending[record] {
+			continue
+		}
+
+		delete(state.pending, record)
+		if len(state.pending) == 0 {
+			close(state.removed)
+		}
+	}
+}
+
+// filter returns the targets that do not belong to a draining instance
+func (s *drainSet) filter(targets []target) []target {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.ids) == 0 {
+		return targets
+	}
+
+	filtered := make([]target, 0, len(targets))
+	for _, t := range targets {
+		if _, ok := s.ids[t.InstanceID]; t.InstanceID != "" && ok {
+			log.Debug().Str("instance.id", t.InstanceID).IPAddr("ip", t.IP).Msg("skipping ip addr as instance is draining")
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+
+	return filtered
+}
+
+// drainer consumes the termination lifecycle hook notifications of a source's
+// auto scaling groups. Each terminating instance is removed from every record
+// straight away, and its lifecycle action is completed once every record has
+// been updated without it and resolvers have had time to stop using its ip addrs
+type drainer struct {
+	sqs sqsReceiver
+	asg asgLifecycleCompleter
+
+	// url of the sqs queue receiving lifecycle hook notifications
+	queueURL string
+
+	// names of the auto scaling groups whose instances are drained
+	groups map[string]bool
+
+	// how long to wait after an instance is removed from every record before
+	// completing its lifecycle action
+	wait time.Duration
+
+	// instances currently being drained, shared between drainers
+	draining *drainSet
+}
+
+// newDrainer creates a drainer for an auto scaling group source. The wait is
+// the longest ttl of the source's records plus the configured grace period
+func newDrainer(sqsClient sqsReceiver, asgClient asgLifecycleCompleter, src asgSourceConfig, ttl int64, draining *drainSet) *drainer {
+	groups := make(map[string]bool, len(src.Names))
+	for _, name := range src.Names {
+		groups[name] = true
+	}
+
+	return &drainer{
+		sqs:      sqsClient,
+		asg:      asgClient,
+		queueURL: src.LifecycleHook.QueueURL,
+		groups:   groups,
+		wait:     time.Duration(ttl)*time.Second + src.LifecycleHook.GracePeriod.Duration,
+		draining: draining,
+	}
+}
+
+// watch receives lifecycle hook notifications until stop is closed, calling
+// drained with the id of each instance that starts draining
+func (d *drainer) watch(stop <-chan struct{}, drained func(id string)) {
+	receiveMessages(stop, d.sqs, d.queueURL, func(ctx context.Context, msg *sqs.Message) {
+		d.handle(ctx, msg, drained)
+	})
+}
+
+// handle processes a single lifecycle hook notification. Notifications of
+// other auto scaling groups are left on the queue
+func (d *drainer) handle(ctx context.Context, msg *sqs.Message, drained func(id string)) {
+	var m lifecycleMessage
+	if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &m); err != nil {
+		log.Error().Err(err).Str("queue", d.queueURL).Str("message.id", aws.StringValue(msg.MessageId)).Msg("error decoding lifecycle hook notification")
+		return
+	}
+
+	switch {
+	case m.Event != "" || m.LifecycleTransition != lifecycleTransitionTerminating:
+		// test notifications and launches need no draining
+		deleteMessage(ctx, d.sqs, d.queueURL, msg)
+		return
+	case !d.groups[m.AutoScalingGroupName]:
+		log.Debug().Str("asg", m.AutoScalingGroupName).Msg("skipping lifecycle hook notification of another auto scaling group")
+		return
+	}
+
+	// notifications may be delivered more than once
+	if !d.draining.add(m.EC2InstanceID) {
+		deleteMessage(ctx, d.sqs, d.queueURL, msg)
+		return
+	}
+
+	log.Info().Str("instance.id", m.EC2InstanceID).Str("asg", m.AutoScalingGroupName).Msg("instance terminating, removing from every record")
+
+	drained(m.EC2InstanceID)
+
+	// if ingressd stops before completing the action, the hook times out with
+	// its default result
+	deleteMessage(ctx, d.sqs, d.queueURL, msg)
+
+	go d.complete(ctx, m)
+}
+
+// complete waits until a terminating instance has been removed from every
+// record, then for the drain period, before completing its lifecycle action so
+// the auto scaling group can terminate it. If a record is never updated, the
+// hook times out with its default result instead
+func (d *drainer) complete(ctx context.Context, m lifecycleMessage) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-d.draining.removed(m.EC2InstanceID):
+	}
+
+	log.Info().Str("instance.id", m.EC2InstanceID).Str("asg", m.AutoScalingGroupName).Msgf("instance removed from every record, draining for %s", d.wait)
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(d.wait):
+	}
+
+	_, err := d.asg.CompleteLifecycleActionWithContext(ctx, &autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(m.AutoScalingGroupName),
+		InstanceId:            aws.String(m.EC2InstanceID),
+		LifecycleActionResult: aws.String(lifecycleActionContinue),
+		LifecycleActionToken:  aws.String(m.LifecycleActionToken),
+		LifecycleHookName:     aws.String(m.LifecycleHookName),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("instance.id", m.EC2InstanceID).Str("asg", m.AutoScalingGroupName).Msg("error completing lifecycle action")
+	} else {
+		log.Info().Str("instance.id", m.EC2InstanceID).Str("asg", m.AutoScalingGroupName).Msg("instance drained, completed lifecycle action")
+	}
+
+	// the instance is kept out of every record until it has terminated
+	time.AfterFunc(drainRetention, func() {
+		d.draining.remove(m.EC2InstanceID)
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+type mockSQSReceiver struct {
+	receiveFunc func(aws.Context, *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	deleteFunc  func(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+}
+
+func (m mockSQSReceiver) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	return m.receiveFunc(ctx, input)
+}
+
+func (m mockSQSReceiver) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	return m.deleteFunc(input)
+}
+
+type mockASGLifecycleCompleter struct {
+	completeFunc func(*autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error)
+}
+
+func (m mockASGLifecycleCompleter) CompleteLifecycleActionWithContext(ctx aws.Context, input *autoscaling.CompleteLifecycleActionInput, opts ...request.Option) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	return m.completeFunc(input)
+}
+
+// lifecycleNotification builds an sqs message with the given body and receipt handle
+func lifecycleNotification(receipt, body string) *sqs.Message {
+	return &sqs.Message{
+		MessageId:     aws.String(receipt),
+		ReceiptHandle: aws.String(receipt),
+		Body:          aws.String(body),
+	}
+}
+
+func TestDrainSetFilter(t *testing.T) {
+	t.Parallel()
+
+	s := newDrainSet()
+	targets := []target{
+		{IP: net.ParseIP("192.168.0.1"), InstanceID: "i-1"},
+		{IP: net.ParseIP("192.168.0.2"), InstanceID: "i-2"},
+		{IP: net.ParseIP("192.168.0.3")},
+	}
+
+	if !s.add("i-1") {
+		t.Errorf("expected i-1 to be added")
+	}
+	if s.add("i-1") {
+		t.Errorf("expected i-1 to already be draining")
+	}
+
+	if filtered := s.filter(targets); len(filtered) != 2 || filtered[0].InstanceID != "i-2" {
+		t.Errorf("expected draining instance to be filtered, got: %v", filtered)
+	}
+
+	s.remove("i-1")
+	if filtered := s.filter(targets); len(filtered) != 3 {
+		t.Errorf("expected no targets to be filtered, got: %v", filtered)
+	}
+}
+
+func TestDrainSetApplied(t *testing.T) {
+	t.Parallel()
+
+	s := newDrainSet()
+	s.records = func(id string) []string {
+		if id == "i-1" {
+			return []string{"syscll.org", "ingress.syscll.org"}
+		}
+		return nil
+	}
+
+	removed := func(id string) bool {
+		select {
+		case <-s.removed(id):
+			return true
+		default:
+			return false
+		}
+	}
+
+	// a poll that filtered its targets before the instance was added
+	mark := s.mark()
+	s.add("i-1")
+	s.applied("syscll.org", mark)
+	s.applied("ingress.syscll.org", mark)
+	if removed("i-1") {
+		t.Fatalf("expected records of an earlier poll not to remove the instance")
+	}
+
+	mark = s.mark()
+	s.applied("syscll.org", mark)
+	if removed("i-1") {
+		t.Fatalf("expected the instance to still be published by ingress.syscll.org")
+	}
+
+	s.applied("ingress.syscll.org", mark)
+	if !removed("i-1") {
+		t.Errorf("expected the instance to be removed from every record")
+	}
+
+	// an instance not published by any record is removed straight away
+	s.add("i-2")
+	if !removed("i-2") {
+		t.Errorf("expected an unpublished instance to be removed")
+	}
+}
+
+func TestDrainerWatch(t *testing.T) {
+	t.Parallel()
+
+	const terminating = `{"LifecycleTransition":"autoscaling:EC2_INSTANCE_TERMINATING","LifecycleHookName":"drain","LifecycleActionToken":"token","AutoScalingGroupName":"%s","EC2InstanceId":"%s"}`
+
+	// a fake queue delivering a single batch of notifications, then waiting
+	// for messages until the drainer stops
+	batch := []*sqs.Message{
+		lifecycleNotification("test", `{"Event":"autoscaling:TEST_NOTIFICATION","AutoScalingGroupName":"haproxy"}`),
+		lifecycleNotification("launch", `{"LifecycleTransition":"autoscaling:EC2_INSTANCE_LAUNCHING","AutoScalingGroupName":"haproxy","EC2InstanceId":"i-0"}`),
+		lifecycleNotification("other", fmt.Sprintf(terminating, "other", "i-9")),
+		lifecycleNotification("invalid", "{"),
+		lifecycleNotification("terminate", fmt.Sprintf(terminating, "haproxy", "i-1")),
+		lifecycleNotification("duplicate", fmt.Sprintf(terminating, "haproxy", "i-1")),
+	}
+
+	var mu sync.Mutex
+	var deleted []string
+
+	queue := mockSQSReceiver{
+		receiveFunc: func(ctx aws.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+			mu.Lock()
+			msgs := batch
+			batch = nil
+			mu.Unlock()
+
+			if len(msgs) > 0 {
+				return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+			}
+
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		deleteFunc: func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+			mu.Lock()
+			deleted = append(deleted, aws.StringValue(input.ReceiptHandle))
+			mu.Unlock()
+			return &sqs.DeleteMessageOutput{}, nil
+		},
+	}
+
+	completed := make(chan *autoscaling.CompleteLifecycleActionInput, 1)
+	asg := mockASGLifecycleCompleter{
+		completeFunc: func(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+			completed <- input
+			return &autoscaling.CompleteLifecycleActionOutput{}, nil
+		},
+	}
+
+	src := asgSourceConfig{
+		Names:         []string{"haproxy"},
+		LifecycleHook: &lifecycleHookConfig{QueueURL: "queue", GracePeriod: duration{10 * time.Millisecond}},
+	}
+	draining := newDrainSet()
+	draining.records = func(string) []string { return []string{"syscll.org"} }
+	d := newDrainer(queue, asg, src, 0, draining)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	drained := make(chan string, 1)
+	go d.watch(stop, func(id string) { drained <- id })
+
+	select {
+	case id := <-drained:
+		if id != "i-1" {
+			t.Errorf("expected drained instance: i-1, got: %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a change when an instance starts draining")
+	}
+
+	// the lifecycle action is only completed once the instance is removed
+	// from every record
+	select {
+	case <-completed:
+		t.Fatalf("expected the lifecycle action to wait for the record to be updated")
+	case <-time.After(50 * time.Millisecond):
+	}
+	draining.applied("syscll.org", draining.mark())
+
+	var input *autoscaling.CompleteLifecycleActionInput
+	select {
+	case input = <-completed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the lifecycle action to be completed")
+	}
+
+	if aws.StringValue(input.InstanceId) != "i-1" || aws.StringValue(input.LifecycleActionResult) != lifecycleActionContinue || aws.StringValue(input.LifecycleActionToken) != "token" {
+		t.Errorf("unexpected lifecycle action: %+v", input)
+	}
+
+	// the instance stays excluded until it has terminated
+	if filtered := draining.filter([]target{{InstanceID: "i-1"}}); len(filtered) != 0 {
+		t.Errorf("expected drained instance to be excluded, got: %v", filtered)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	sort.Strings(deleted)
+	if expected := "[duplicate launch terminate test]"; fmt.Sprint(deleted) != expected {
+		t.Errorf("expected deleted messages: %s, got: %v", expected, deleted)
+	}
+}
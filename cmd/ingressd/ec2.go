@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maximum number of instances returned by a single DescribeInstances page
+	ec2MaxResults = 1000
+)
+
+// ec2Discoverer discovers the ip addrs of running ec2 instances matching a
+// tag selector
+type ec2Discoverer struct {
+	ec2 ec2Describer
+
+	// ipv4 addr published for each instance: public, private or eip
+	address string
+
+	// only publish the addrs of the network interface attached at this device index
+	deviceIndex *int64
+
+	// tags used to query for instances
+	sel tagSelector
+}
+
+// newEC2Discoverer creates an ec2 discoverer for a source and tag selector
+func newEC2Discoverer(client ec2Describer, src ec2SourceConfig, sel tagSelector) *ec2Discoverer {
+	return &ec2Discoverer{
+		ec2:         client,
+		address:     src.Address,
+		deviceIndex: src.DeviceIndex,
+		sel:         sel,
+	}
+}
+
+// Discover queries ec2 for all running instances matching the tag selector,
+// returning a target for each of the addrs chosen by the source
+func (d *ec2Discoverer) Discover(ctx context.Context) ([]target, error) {
+	// instances may match several queries, but are only returned once
+	seen := make(map[string]bool)
+
+	var targets []target
+	for _, filters := range d.sel.queries() {
+		instances, err := describeRunningInstances(ctx, d.ec2, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, instance := range instances {
+			id := aws.StringValue(instance.InstanceId)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			if d.sel.excludes(instance.Tags) {
+				log.Debug().Str("instance.id", id).Msg("skipping instance as it matches an excluded tag")
+				continue
+			}
+
+			targets = append(targets, instanceTargets(instance, d.address, d.deviceIndex)...)
+		}
+	}
+
+	return targets, nil
+}
+
+// instanceTargets returns a target for each of the ip addrs of an instance
+// chosen by the given address mode and device index
+func instanceTargets(instance *ec2.Instance, mode string, deviceIndex *int64) []target {
+	id := aws.StringValue(instance.InstanceId)
+
+	ips := instanceIPAddrs(instance, mode, deviceIndex)
+	if len(ips) == 0 {
+		log.Debug().Str("instance.id", id).Str("address", mode).Msg("skipping instance as it has no matching ip addrs")
+		return nil
+	}
+
+	var az string
+	if instance.Placement != nil {
+		az = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+
+	labels := make(map[string]string, len(instance.Tags))
+	for _, tag := range instance.Tags {
+		labels[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	targets := make([]target, 0, len(ips))
+	for _, ip := range ips {
+		targets = append(targets, target{
+			IP:         ip,
+			InstanceID: id,
+			AZ:         az,
+			Labels:     labels,
+		})
+	}
+
+	return targets
+}
+
+// describeRunningInstances lists all running instances matching the given
+// filters, following pagination
+func describeRunningInstances(ctx context.Context, client ec2Describer, filters []*ec2.Filter) ([]*ec2.Instance, error) {
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name: aws.String("instance-state-name"),
+				Values: []*string{
+					aws.String(ec2.InstanceStateNameRunning),
+				},
+			},
+		},
+		MaxResults: aws.Int64(ec2MaxResults),
+	}
+	input.Filters = append(input.Filters, filters...)
+
+	var instances []*ec2.Instance
+	for {
+		res, err := client.DescribeInstancesWithContext(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("error describing instances: %w", err)
+		}
+
+		for _, reservation := range res.Reservations {
+			for _, instance := range reservation.Instances {
+				// instances are filtered by state, but may have changed state
+				// between pages
+				if instance.State != nil && aws.StringValue(instance.State.Name) != ec2.InstanceStateNameRunning {
+					log.Info().Str("instance.id", aws.StringValue(instance.InstanceId)).Msg("skipping instance as state != running")
+					continue
+				}
+
+				instances = append(instances, instance)
+			}
+		}
+
+		if aws.StringValue(res.NextToken) == "" {
+			return instances, nil
+		}
+		input.NextToken = res.NextToken
+	}
+}
+
+// instanceIPAddrs returns the ipv4 addr of an instance chosen by the given
+// address mode, if any, followed by its ipv6 addrs. If a device index is given,
+// only the network interface attached at that index is used
+func instanceIPAddrs(instance *ec2.Instance, mode string, deviceIndex *int64) []net.IP {
+	var candidates []string
+
+	enis := instance.NetworkInterfaces
+	if deviceIndex != nil {
+		enis = nil
+		for _, eni := range instance.NetworkInterfaces {
+			if eni.Attachment != nil && aws.Int64Value(eni.Attachment.DeviceIndex) == *deviceIndex {
+				enis = append(enis, eni)
+			}
+		}
+
+		for _, eni := range enis {
+			switch mode {
+			case addressPublic:
+				if eni.Association != nil {
+					candidates = append(candidates, aws.StringValue(eni.Association.PublicIp))
+				}
+			case addressPrivate:
+				candidates = append(candidates, aws.StringValue(eni.PrivateIpAddress))
+			}
+		}
+	} else {
+		switch mode {
+		case addressPublic:
+			candidates = append(candidates, aws.StringValue(instance.PublicIpAddress))
+		case addressPrivate:
+			candidates = append(candidates, aws.StringValue(instance.PrivateIpAddress))
+		}
+	}
+
+	// elastic ips are owned by an account, whereas auto-assigned public ips
+	// are owned by amazon
+	if mode == addressElastic {
+		for _, eni := range enis {
+			for _, addr := range eni.PrivateIpAddresses {
+				if addr.Association != nil && aws.StringValue(addr.Association.IpOwnerId) != "amazon" {
+					candidates = append(candidates, aws.StringValue(addr.Association.PublicIp))
+				}
+			}
+		}
+	}
+
+	// ipv6 addrs are globally routable, so are assigned to network interfaces
+	// rather than associated with the instance
+	for _, eni := range enis {
+		for _, addr := range eni.Ipv6Addresses {
+			candidates = append(candidates, aws.StringValue(addr.Ipv6Address))
+		}
+	}
+
+	var ips []net.IP
+	for _, c := range candidates {
+		// check ip addr is valid
+		if ip := net.ParseIP(c); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
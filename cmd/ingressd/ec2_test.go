@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+type mockEC2Describer struct {
+	describeFunc func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	err          error
+}
+
+func (m mockEC2Describer) DescribeInstancesWithContext(ctx aws.Context, input *ec2.DescribeInstancesInput, opts ...request.Option) (*ec2.DescribeInstancesOutput, error) {
+	return m.describeFunc(input)
+}
+
+func TestEC2Discover(t *testing.T) {
+	t.Parallel()
+
+	testTable := make(map[string]mockEC2Describer)
+
+	testTable["TestListHostedZonesError"] = mockEC2Describer{
+		describeFunc: func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return nil, fmt.Errorf("aws error")
+		},
+		err: fmt.Errorf("error describing instances: aws error"),
+	}
+
+	testTable["TestSuccess"] = mockEC2Describer{
+		describeFunc: func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					{
+						Instances: []*ec2.Instance{
+							{
+								InstanceId:      aws.String("1"),
+								PublicIpAddress: aws.String("192.168.0.1"),
+								State: &ec2.InstanceState{
+									Name: aws.String(ec2.InstanceStateNameRunning),
+								},
+							},
+							{
+								InstanceId:      aws.String("2"),
+								PublicIpAddress: aws.String("192.168.0.2"),
+								State: &ec2.InstanceState{
+									Name: aws.String(ec2.InstanceStateNameRunning),
+								},
+							},
+							{
+								InstanceId:      aws.String("3"),
+								PublicIpAddress: aws.String("192.168.0.3"),
+								State: &ec2.InstanceState{
+									Name: aws.String(ec2.InstanceStateNameTerminated),
+								},
+							},
+							{
+								InstanceId:      aws.String("4"),
+								PublicIpAddress: aws.String("192.168.0.4"),
+								State: &ec2.InstanceState{
+									Name: aws.String(ec2.InstanceStateNameStopping),
+								},
+							},
+						},
+					},
+				},
+			}, nil
+		},
+		err: nil,
+	}
+
+	for name, test := range testTable {
+		t.Run(name, func(t *testing.T) {
+			d := newEC2Discoverer(test, ec2SourceConfig{Address: addressPublic}, tagSelector{Tags: []tagFilter{{Key: "key", Value: "value"}}})
+
+			targets, err := d.Discover(context.Background())
+			if test.err != nil && err.Error() != test.err.Error() {
+				t.Errorf("expected error: '%v', got: '%v'", test.err, err)
+			}
+			if test.err == nil {
+				if err != nil {
+					t.Errorf("expected error: nil, got: %v", err)
+				}
+
+				for _, ip := range targetIPs(targets) {
+					if ip.String() != "192.168.0.1" && ip.String() != "192.168.0.2" {
+						t.Fatalf("incorrect list of targets: %v", targets)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEC2DiscoverPagination(t *testing.T) {
+	t.Parallel()
+
+	instance := func(ip string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId:      aws.String(ip),
+			PublicIpAddress: aws.String(ip),
+			State: &ec2.InstanceState{
+				Name: aws.String(ec2.InstanceStateNameRunning),
+			},
+		}
+	}
+
+	pages := map[string]*ec2.DescribeInstancesOutput{
+		"": {
+			Reservations: []*ec2.Reservation{
+				{Instances: []*ec2.Instance{instance("192.168.0.1"), instance("192.168.0.2")}},
+			},
+			NextToken: aws.String("page-2"),
+		},
+		"page-2": {
+			Reservations: []*ec2.Reservation{
+				{Instances: []*ec2.Instance{instance("192.168.0.3")}},
+				{Instances: []*ec2.Instance{instance("192.168.0.4")}},
+			},
+			NextToken: aws.String("page-3"),
+		},
+		"page-3": {
+			Reservations: []*ec2.Reservation{
+				{Instances: []*ec2.Instance{instance("192.168.0.5")}},
+			},
+		},
+	}
+
+	var tokens []string
+	d := newEC2Discoverer(
+		mockEC2Describer{
+			describeFunc: func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+				filters := make(map[string]string)
+				for _, f := range input.Filters {
+					filters[aws.StringValue(f.Name)] = aws.StringValue(f.Values[0])
+				}
+				if filters["tag:key"] != "value" {
+					return nil, fmt.Errorf("expected tag filter, got: %v", filters)
+				}
+				if filters["instance-state-name"] != ec2.InstanceStateNameRunning {
+					return nil, fmt.Errorf("expected state filter, got: %v", filters)
+				}
+
+				token := aws.StringValue(input.NextToken)
+				tokens = append(tokens, token)
+
+				page, ok := pages[token]
+				if !ok {
+					return nil, fmt.Errorf("unexpected token: %s", token)
+				}
+				return page, nil
+			},
+		},
+		ec2SourceConfig{Address: addressPublic},
+		tagSelector{Tags: []tagFilter{{Key: "key", Value: "value"}}},
+	)
+
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	if len(tokens) != 3 {
+		t.Errorf("expected 3 pages to be described, got: %d", len(tokens))
+	}
+
+	ips := targetIPs(targets)
+	expected := []string{"192.168.0.1", "192.168.0.2", "192.168.0.3", "192.168.0.4", "192.168.0.5"}
+	if len(ips) != len(expected) {
+		t.Fatalf("expected ip addrs: %v, got: %v", expected, ips)
+	}
+	for i, ip := range ips {
+		if ip.String() != expected[i] {
+			t.Errorf("expected ip addr: %s, got: %s", expected[i], ip)
+		}
+	}
+
+	// an error on a later page fails the whole lookup
+	pages["page-2"].NextToken = aws.String("missing")
+	if _, err := d.Discover(context.Background()); err == nil || err.Error() != "error describing instances: unexpected token: missing" {
+		t.Errorf("expected page error, got: %v", err)
+	}
+}
+
+func TestEC2DiscoverSelector(t *testing.T) {
+	t.Parallel()
+
+	instance := func(id string, tags map[string]string) *ec2.Instance {
+		instance := &ec2.Instance{
+			InstanceId:      aws.String(id),
+			PublicIpAddress: aws.String("192.168.0." + id),
+		}
+		for k, v := range tags {
+			instance.Tags = append(instance.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		return instance
+	}
+
+	// instances returned by each query, keyed by its tag filters
+	results := map[string][]*ec2.Instance{
+		"tag:Role=ingress": {
+			instance("1", map[string]string{"Role": "ingress"}),
+			instance("2", map[string]string{"Role": "ingress", "Draining": "true"}),
+		},
+		"tag:Role=ingress,tag:Env=prod|staging": {
+			instance("1", map[string]string{"Role": "ingress", "Env": "prod"}),
+			instance("3", map[string]string{"Role": "ingress", "Env": "staging", "Draining": "false"}),
+		},
+		"tag-key=Public": {
+			instance("1", map[string]string{"Public": ""}),
+			instance("4", map[string]string{"Public": ""}),
+		},
+		"tag:Role=dual-stack": {
+			{
+				InstanceId:      aws.String("5"),
+				PublicIpAddress: aws.String("192.168.0.5"),
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					{Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::5")}}},
+					{Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::6")}}},
+				},
+			},
+			{
+				InstanceId: aws.String("6"),
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+					{Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::7")}}},
+				},
+			},
+		},
+	}
+
+	client := mockEC2Describer{
+		describeFunc: func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			var filters []string
+			for _, f := range input.Filters {
+				if aws.StringValue(f.Name) == "instance-state-name" {
+					continue
+				}
+				filters = append(filters, aws.StringValue(f.Name)+"="+strings.Join(aws.StringValueSlice(f.Values), "|"))
+			}
+
+			key := strings.Join(filters, ",")
+			instances, ok := results[key]
+			if !ok {
+				return nil, fmt.Errorf("unexpected filters: %s", key)
+			}
+
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: instances}},
+			}, nil
+		},
+	}
+
+	testTable := map[string]struct {
+		sel      tagSelector
+		expected []string
+	}{
+		"TestSingleTag": {
+			sel:      tagSelector{Tags: []tagFilter{{Key: "Role", Value: "ingress"}}},
+			expected: []string{"192.168.0.1", "192.168.0.2"},
+		},
+		"TestAllTags": {
+			sel: tagSelector{Tags: []tagFilter{
+				{Key: "Role", Value: "ingress"},
+				{Key: "Env", Values: []string{"prod", "staging"}},
+			}},
+			expected: []string{"192.168.0.1", "192.168.0.3"},
+		},
+		"TestAnyTag": {
+			sel: tagSelector{Match: tagMatchAny, Tags: []tagFilter{
+				{Key: "Role", Value: "ingress"},
+				{Key: "Public"},
+			}},
+			expected: []string{"192.168.0.1", "192.168.0.2", "192.168.0.4"},
+		},
+		"TestExcludeKey": {
+			sel: tagSelector{
+				Tags:    []tagFilter{{Key: "Role", Value: "ingress"}},
+				Exclude: []tagFilter{{Key: "Draining"}},
+			},
+			expected: []string{"192.168.0.1"},
+		},
+		"TestIPv6Addrs": {
+			sel:      tagSelector{Tags: []tagFilter{{Key: "Role", Value: "dual-stack"}}},
+			expected: []string{"192.168.0.5", "2001:db8::5", "2001:db8::6", "2001:db8::7"},
+		},
+		"TestExcludeValue": {
+			sel: tagSelector{
+				Tags: []tagFilter{
+					{Key: "Role", Value: "ingress"},
+					{Key: "Env", Values: []string{"prod", "staging"}},
+				},
+				Exclude: []tagFilter{{Key: "Draining", Value: "true"}},
+			},
+			expected: []string{"192.168.0.1", "192.168.0.3"},
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			targets, err := newEC2Discoverer(client, ec2SourceConfig{Address: addressPublic}, test.sel).Discover(context.Background())
+			if err != nil {
+				t.Fatalf("expected error: nil, got: %v", err)
+			}
+
+			ips := targetIPs(targets)
+			if len(ips) != len(test.expected) {
+				t.Fatalf("expected ip addrs: %v, got: %v", test.expected, ips)
+			}
+			for i, ip := range ips {
+				if ip.String() != test.expected[i] {
+					t.Errorf("expected ip addr: %s, got: %s", test.expected[i], ip)
+				}
+			}
+		})
+	}
+}
+
+func TestEC2DiscoverMetadata(t *testing.T) {
+	t.Parallel()
+
+	client := mockEC2Describer{
+		describeFunc: func(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+			return &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{
+					{
+						Instances: []*ec2.Instance{
+							{
+								InstanceId:      aws.String("i-0123"),
+								PublicIpAddress: aws.String("192.168.0.1"),
+								Placement:       &ec2.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+								Tags:            []*ec2.Tag{{Key: aws.String("Role"), Value: aws.String("ingress")}},
+								NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+									{Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::1")}}},
+								},
+							},
+						},
+					},
+				},
+			}, nil
+		},
+	}
+
+	d := newEC2Discoverer(client, ec2SourceConfig{Address: addressPublic}, tagSelector{Tags: []tagFilter{{Key: "Role"}}})
+
+	targets, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got: %v", targets)
+	}
+	for _, target := range targets {
+		if target.InstanceID != "i-0123" || target.AZ != "eu-west-1a" || target.Labels["Role"] != "ingress" {
+			t.Errorf("unexpected target metadata: %+v", target)
+		}
+	}
+}
+
+func TestInstanceIPAddrs(t *testing.T) {
+	t.Parallel()
+
+	// an instance with an auto-assigned public ip on its primary interface and an
+	// elastic ip, along with an ipv6 addr, on its secondary interface
+	instance := &ec2.Instance{
+		InstanceId:       aws.String("1"),
+		PublicIpAddress:  aws.String("203.0.113.1"),
+		PrivateIpAddress: aws.String("10.0.0.1"),
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{
+				Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+				Association:      &ec2.InstanceNetworkInterfaceAssociation{IpOwnerId: aws.String("amazon"), PublicIp: aws.String("203.0.113.1")},
+				PrivateIpAddress: aws.String("10.0.0.1"),
+				PrivateIpAddresses: []*ec2.InstancePrivateIpAddress{
+					{
+						PrivateIpAddress: aws.String("10.0.0.1"),
+						Association:      &ec2.InstanceNetworkInterfaceAssociation{IpOwnerId: aws.String("amazon"), PublicIp: aws.String("203.0.113.1")},
+					},
+				},
+			},
+			{
+				Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)},
+				Association:      &ec2.InstanceNetworkInterfaceAssociation{IpOwnerId: aws.String("123456789012"), PublicIp: aws.String("198.51.100.1")},
+				PrivateIpAddress: aws.String("10.0.1.1"),
+				PrivateIpAddresses: []*ec2.InstancePrivateIpAddress{
+					{
+						PrivateIpAddress: aws.String("10.0.1.1"),
+						Association:      &ec2.InstanceNetworkInterfaceAssociation{IpOwnerId: aws.String("123456789012"), PublicIp: aws.String("198.51.100.1")},
+					},
+					{
+						PrivateIpAddress: aws.String("10.0.1.2"),
+					},
+				},
+				Ipv6Addresses: []*ec2.InstanceIpv6Address{{Ipv6Address: aws.String("2001:db8::1")}},
+			},
+		},
+	}
+
+	testTable := map[string]struct {
+		mode        string
+		deviceIndex *int64
+		expected    []string
+	}{
+		"TestPublic": {
+			mode:     addressPublic,
+			expected: []string{"203.0.113.1", "2001:db8::1"},
+		},
+		"TestPrivate": {
+			mode:     addressPrivate,
+			expected: []string{"10.0.0.1", "2001:db8::1"},
+		},
+		"TestElastic": {
+			mode:     addressElastic,
+			expected: []string{"198.51.100.1", "2001:db8::1"},
+		},
+		"TestDeviceIndexPublic": {
+			mode:        addressPublic,
+			deviceIndex: aws.Int64(1),
+			expected:    []string{"198.51.100.1", "2001:db8::1"},
+		},
+		"TestDeviceIndexPrivate": {
+			mode:        addressPrivate,
+			deviceIndex: aws.Int64(1),
+			expected:    []string{"10.0.1.1", "2001:db8::1"},
+		},
+		"TestDeviceIndexElastic": {
+			mode:        addressElastic,
+			deviceIndex: aws.Int64(0),
+			expected:    nil,
+		},
+		"TestMissingDeviceIndex": {
+			mode:        addressPrivate,
+			deviceIndex: aws.Int64(2),
+			expected:    nil,
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			ips := instanceIPAddrs(instance, test.mode, test.deviceIndex)
+			if len(ips) != len(test.expected) {
+				t.Fatalf("expected ip addrs: %v, got: %v", test.expected, ips)
+			}
+			for i, ip := range ips {
+				if ip.String() != test.expected[i] {
+					t.Errorf("expected ip addr: %s, got: %s", test.expected[i], ip)
+				}
+			}
+		})
+	}
+}
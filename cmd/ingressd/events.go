@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// eventbridge detail type of ec2 instance state-change notifications
+	ec2StateChangeDetailType = "EC2 Instance State-change Notification"
+)
+
+// stateChangeEvent is an ec2 instance state-change notification delivered to
+// sqs by eventbridge
+type stateChangeEvent struct {
+	DetailType string `json:"detail-type"`
+
+	// aws region of the instance
+	Region string `json:"region"`
+
+	Detail struct {
+		InstanceID string `json:"instance-id"`
+		State      string `json:"state"`
+	} `json:"detail"`
+}
+
+// running reports whether the instance of the event has started running, so
+// it may now be discovered by sources that have never seen it
+func (ev stateChangeEvent) running() bool {
+	return ev.Detail.State == ec2.InstanceStateNameRunning
+}
+
+// eventWatcher consumes ec2 instance state-change notifications from an sqs queue
+type eventWatcher struct {
+	sqs sqsReceiver
+
+	// url of the sqs queue receiving state-change notifications
+	queueURL string
+}
+
+func newEventWatcher(client sqsReceiver, queueURL string) *eventWatcher {
+	return &eventWatcher{
+		sqs:      client,
+		queueURL: queueURL,
+	}
+}
+
+// watch receives state-change notifications until stop is closed, calling
+// changed with each event
+func (w *eventWatcher) watch(stop <-chan struct{}, changed func(stateChangeEvent)) {
+	receiveMessages(stop, w.sqs, w.queueURL, func(ctx context.Context, msg *sqs.Message) {
+		w.handle(ctx, msg, changed)
+	})
+}
+
+// handle processes a single notification. Notifications that cannot be decoded
+// are left on the queue, any other events are discarded
+func (w *eventWatcher) handle(ctx context.Context, msg *sqs.Message, changed func(stateChangeEvent)) {
+	var ev stateChangeEvent
+	if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &ev); err != nil {
+		log.Error().Err(err).Str("queue", w.queueURL).Str("message.id", aws.StringValue(msg.MessageId)).Msg("error decoding state-change notification")
+		return
+	}
+
+	if ev.DetailType != ec2StateChangeDetailType {
+		log.Debug().Str("queue", w.queueURL).Str("detail_type", ev.DetailType).Msg("skipping event as it is not an ec2 state-change notification")
+		deleteMessage(ctx, w.sqs, w.queueURL, msg)
+		return
+	}
+
+	log.Info().Str("instance.id", ev.Detail.InstanceID).Str("state", ev.Detail.State).Str("region", ev.Region).Msg("instance state changed")
+
+	changed(ev)
+	deleteMessage(ctx, w.sqs, w.queueURL, msg)
+}
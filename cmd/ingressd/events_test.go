@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestEventWatcherWatch(t *testing.T) {
+	t.Parallel()
+
+	const stateChange = `{"detail-type":"EC2 Instance State-change Notification","source":"aws.ec2","region":"eu-west-1","detail":{"instance-id":"%s","state":"%s"}}`
+
+	batch := []*sqs.Message{
+		lifecycleNotification("other", `{"detail-type":"EC2 Spot Instance Interruption Warning","region":"eu-west-1"}`),
+		lifecycleNotification("invalid", "{"),
+		lifecycleNotification("stopping", fmt.Sprintf(stateChange, "i-1", "stopping")),
+	}
+
+	var mu sync.Mutex
+	var deleted []string
+
+	queue := mockSQSReceiver{
+		receiveFunc: func(ctx aws.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+			mu.Lock()
+			msgs := batch
+			batch = nil
+			mu.Unlock()
+
+			if len(msgs) > 0 {
+				return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+			}
+
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		deleteFunc: func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+			mu.Lock()
+			deleted = append(deleted, aws.StringValue(input.ReceiptHandle))
+			mu.Unlock()
+			return &sqs.DeleteMessageOutput{}, nil
+		},
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	events := make(chan stateChangeEvent, 1)
+	go newEventWatcher(queue, "queue").watch(stop, func(ev stateChangeEvent) { events <- ev })
+
+	select {
+	case ev := <-events:
+		if ev.Detail.InstanceID != "i-1" || ev.Detail.State != "stopping" || ev.Region != "eu-west-1" || ev.running() {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a state-change event")
+	}
+
+	// the event is deleted once handled, while undecodable messages are kept
+	timeout := time.After(5 * time.Second)
+	for {
+		mu.Lock()
+		got := fmt.Sprint(deleted)
+		mu.Unlock()
+
+		if got == "[other stopping]" {
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("expected deleted messages: [other stopping], got: %s", got)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
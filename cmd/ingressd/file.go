@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// interval at which a file is checked for changes when it cannot be watched
+	filePollInterval = 5 * time.Second
+)
+
+// fileDiscoverer discovers the ip addrs listed in a yaml or json file, in the
+// same format as a static source. The file is read once and then only reloaded
+// when it changes
+type fileDiscoverer struct {
+	path string
+
+	mu      sync.Mutex
+	targets []target
+
+	// error reading the file, returned by Discover until the file is fixed
+	err error
+}
+
+// newFileDiscoverer creates a file discoverer, reading the file immediately
+func newFileDiscoverer(path string) *fileDiscoverer {
+	d := &fileDiscoverer{path: path}
+	d.reload()
+
+	return d
+}
+
+// Discover returns the targets most recently read from the file
+func (d *fileDiscoverer) Discover(context.Context) ([]target, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.targets, d.err
+}
+
+// reload reads the file, reporting whether its targets, or whether it could be
+// read, have changed
+func (d *fileDiscoverer) reload() bool {
+	targets, err := readTargetsFile(d.path)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changed := !reflect.DeepEqual(targets, d.targets) || (err == nil) != (d.err == nil)
+	d.targets, d.err = targets, err
+
+	return changed
+}
+
+// watch reloads the file whenever it changes, calling changed if its targets
+// differ, until stop is closed
+func (d *fileDiscoverer) watch(stop <-chan struct{}, changed func()) {
+	watchFile(d.path, stop, func() {
+		if !d.reload() {
+			return
+		}
+
+		d.mu.Lock()
+		err := d.err
+		d.mu.Unlock()
+
+		if err != nil {
+			log.Error().Err(err).Str("path", d.path).Msg("error reloading targets file")
+		} else {
+			log.Info().Str("path", d.path).Msg("targets file changed, reloaded")
+		}
+
+		changed()
+	})
+}
+
+// readTargetsFile reads and parses the targets listed in a yaml or json file
+func readTargetsFile(path string) ([]target, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading targets file: %w", err)
+	}
+
+	var s staticSourceConfig
+	if err := decodeFile(path, b, &s); err != nil {
+		return nil, fmt.Errorf("error decoding targets file: %w", err)
+	}
+
+	targets, err := s.targets()
+	if err != nil {
+		return nil, fmt.Errorf("invalid targets file: %w", err)
+	}
+
+	return targets, nil
+}
+
+// pollFile calls onChange whenever the size or modification time of a file
+// changes, or it is created or removed, checking at the given interval until
+// stop is closed
+func pollFile(path string, interval time.Duration, stop <-chan struct{}, onChange func()) {
+	last, _ := os.Stat(path)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			fi, _ := os.Stat(path)
+
+			switch {
+			case (fi == nil) != (last == nil):
+				onChange()
+			case fi != nil && (fi.Size() != last.Size() || !fi.ModTime().Equal(last.ModTime())):
+				onChange()
+			}
+
+			last = fi
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTargetsFile(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		file    string
+		content string
+		ips     []string
+		err     string
+	}{
+		"TestYAMLSuccess": {
+			file: "targets.yaml",
+			content: `
+ips: [192.168.0.1]
+targets:
+- ip: 2001:db8::1
+  az: rack-a
+`,
+			ips: []string{"192.168.0.1", "2001:db8::1"},
+		},
+		"TestJSONSuccess": {
+			file:    "targets.json",
+			content: `{"ips": ["192.168.0.1", "192.168.0.2"]}`,
+			ips:     []string{"192.168.0.1", "192.168.0.2"},
+		},
+		"TestUnknownFieldError": {
+			file:    "targets.yaml",
+			content: `addrs: [192.168.0.1]`,
+			err:     "error decoding targets file",
+		},
+		"TestInvalidIPError": {
+			file:    "targets.json",
+			content: `{"ips": ["192.168.0"]}`,
+			err:     "invalid targets file: ips[0]: invalid ip addr: 192.168.0",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			targets, err := readTargetsFile(writeConfigFile(t, test.file, test.content))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected error containing: '%s', got: '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected error: nil, got: %v", err)
+			}
+
+			ips := targetIPs(targets)
+			if len(ips) != len(test.ips) {
+				t.Fatalf("expected ip addrs: %v, got: %v", test.ips, ips)
+			}
+			for i, ip := range ips {
+				if ip.String() != test.ips[i] {
+					t.Errorf("expected ip addr: %s, got: %s", test.ips[i], ip)
+				}
+			}
+		})
+	}
+}
+
+func TestFileDiscovererWatch(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfigFile(t, "targets.yaml", "ips: [192.168.0.1]\n")
+
+	d := newFileDiscoverer(path)
+	targets, err := d.Discover(context.Background())
+	if err != nil || len(targets) != 1 {
+		t.Fatalf("expected a single target, got: %v, %v", targets, err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	changed := make(chan struct{}, 1)
+	go d.watch(stop, func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	// files are commonly replaced by renaming, so the new file is written
+	// alongside it first. the watcher may not have started yet, so writes are
+	// retried until a change is seen
+	tmp := filepath.Join(filepath.Dir(path), ".targets.yaml.tmp")
+	timeout := time.After(10 * time.Second)
+	for seen := false; !seen; {
+		if err := ioutil.WriteFile(tmp, []byte("ips: [192.168.0.1, 192.168.0.2]\n"), 0600); err != nil {
+			t.Fatalf("error writing targets file: %v", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatalf("error replacing targets file: %v", err)
+		}
+
+		select {
+		case <-changed:
+			seen = true
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("expected file change to be noticed")
+		}
+	}
+
+	targets, err = d.Discover(context.Background())
+	if err != nil || len(targets) != 2 {
+		t.Errorf("expected reloaded targets, got: %v, %v", targets, err)
+	}
+
+	// an unchanged set of targets is not reported
+	if d.reload() {
+		t.Errorf("expected no change when reloading an unchanged file")
+	}
+
+	// an invalid file is reported until it is fixed
+	if err := ioutil.WriteFile(path, []byte("ips: [invalid]\n"), 0600); err != nil {
+		t.Fatalf("error writing targets file: %v", err)
+	}
+	if !d.reload() {
+		t.Errorf("expected a change when the file becomes invalid")
+	}
+	if _, err := d.Discover(context.Background()); err == nil {
+		t.Errorf("expected error discovering an invalid file, got: nil")
+	}
+}
+
+func TestPollFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	changes := make(chan struct{}, 100)
+	go pollFile(path, 10*time.Millisecond, stop, func() { changes <- struct{}{} })
+
+	// the first check may race the write, so the file grows until a change is seen
+	content := "ips: [192.168.0.1]\n"
+	timeout := time.After(10 * time.Second)
+	for seen := false; !seen; {
+		content += "\n"
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("error writing targets file: %v", err)
+		}
+
+		select {
+		case <-changes:
+			seen = true
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			t.Fatalf("expected file write to be noticed")
+		}
+	}
+
+	// discard any changes from the last writes before removing the file
+	time.Sleep(50 * time.Millisecond)
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("error removing targets file: %v", err)
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected file removal to be noticed")
+	}
+}
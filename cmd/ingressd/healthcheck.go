@@ -1,21 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	// the number of successful health check responses required,
+	// the default number of successful health check responses required,
 	// per scheme, for an ip/host check to pass
 	healthCheckSuccess = 3
 )
@@ -26,96 +32,227 @@ type httpDoer interface {
 }
 
 var (
-	// Default http client with a 10 second timeout.
+	// Default http client. Each request is bound by the health check timeout
+	// of its record, so the client has no timeout of its own.
 	// TLS verification must be skipped as we perform queries on
 	// ip addrs, not urls.
 	httpClient = &http.Client{
-		Timeout: 10 * time.Second,
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
 	}
 
-	// Prometheus gauge for storing number of failed health checks
-	healthCheckFailures = prometheus.NewGauge(prometheus.GaugeOpts{
+	// Prometheus gauge for storing number of failed health checks of each record
+	healthCheckFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ingressd_health_check_failures",
-		Help: "Current number of failing healh checks",
-	})
+		Help: "Current number of failing healh checks of a record",
+	}, []string{"record"})
 )
 
 // ensureHostHealthChecks performs multiple http/s health checks on a given ip/host.
-// the number of successful attempts MUST match the required amount in order for
+// the number of successful attempts MUST match the configured amount in order for
 // this method to return err == nil
-func ensureHostHealthChecks(httpClient httpDoer, ip net.IP, host string) error {
-	// we MUST perform health checks on both http and https protocols
-	schemes := []string{"http", "https"}
-
+func ensureHostHealthChecks(ctx context.Context, httpClient httpDoer, ip net.IP, host string, cfg healthCheckConfig) error {
 	// success counter should be incremented after each successful health check
 	var success uint64
 
-	// attempt to validate the host url, any errors should be treated as fatal
-	u, err := url.Parse(ip.String())
-	if err != nil {
-		return fmt.Errorf("error parsing host url: %w", err)
+	// the most recent failure reason, returned to the caller if any check fails
+	var mu sync.Mutex
+	var lastErr error
+
+	// attempt to validate the url of each configured scheme, any errors should
+	// be treated as fatal
+	urls := make([]*url.URL, 0, len(cfg.Schemes))
+	for _, scheme := range cfg.Schemes {
+		u, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, urlHost(ip), cfg.Path))
+		if err != nil {
+			return fmt.Errorf("error parsing host url: %w", err)
+		}
+		urls = append(urls, u)
 	}
 
 	var wg sync.WaitGroup
 
-	for _, scheme := range schemes {
-		for i := 0; i < healthCheckSuccess; i++ {
+	for _, u := range urls {
+		for i := 0; i < cfg.Attempts; i++ {
 			wg.Add(1)
-			go func(scheme string) {
+			go func(u *url.URL) {
 				defer wg.Done()
 
-				logCtx := map[string]interface{}{
-					"url":  u.String(),
-					"host": host,
-					"ip":   ip,
-				}
-
-				// attempt to create http request, any errors should be treated as fatal
-				// as the arguments will not change on the next iteration
-				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s", scheme, u), nil)
-				if err != nil {
-					log.Error().Err(err).Fields(logCtx).Msg("error building http request")
-					return
-				}
-
-				// as we are using the server ip in the http request, we need to set
-				// the host manually
-				req.Host = host
+				if err := checkHost(ctx, httpClient, u, host, cfg); err != nil {
+					log.Error().Err(err).Str("url", u.String()).Str("host", host).IPAddr("ip", ip).Msg("health check failed")
 
-				// attempt to perform http request
-				res, err := httpClient.Do(req)
-				if err != nil {
-					log.Error().Err(err).Fields(logCtx).Msg("error performing http request")
-					return
-				}
-
-				// we don't read the body so an error shouldn't be classed as a failed health check
-				defer res.Body.Close()
-
-				// successful http requests will only return 200 OK
-				if res.StatusCode != http.StatusOK {
-					log.Error().Fields(logCtx).Msgf("invalid http response code: %d", res.StatusCode)
+					mu.Lock()
+					lastErr = err
+					mu.Unlock()
 					return
 				}
 
 				atomic.AddUint64(&success, 1)
-			}(scheme)
+			}(u)
 		}
 	}
 
 	wg.Wait()
 
 	// check success rate == required count
-	passRate := (healthCheckSuccess * len(schemes))
+	passRate := (cfg.Attempts * len(urls))
 	if int(success) != passRate {
 		failed := passRate - int(success)
-		healthCheckFailures.Add(float64(failed))
+		healthCheckFailures.WithLabelValues(host).Add(float64(failed))
+
+		return fmt.Errorf("failed %d out of %d health checks: %w", failed, passRate, lastErr)
+	}
+
+	return nil
+}
 
-		return fmt.Errorf("failed %d out of %d health checks", failed, passRate)
+// checkHost performs a single health check request against a given url,
+// returning an error describing why the check failed
+func checkHost(ctx context.Context, httpClient httpDoer, u *url.URL, host string, cfg healthCheckConfig) error {
+	// each request is bound by the configured health check timeout
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout.Duration)
+	defer cancel()
+
+	// attempt to create http request
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error building http request: %w", err)
+	}
+
+	// as we are using the server ip in the http request, we need to set
+	// the host manually
+	req.Host = host
+
+	// set any additional headers, allowing the host to be overridden
+	for k, v := range cfg.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	// attempt to perform http request
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error performing http request: %w", err)
+	}
+
+	// errors closing the body shouldn't be classed as a failed health check
+	defer res.Body.Close()
+
+	// successful http requests must return one of the accepted status codes
+	if !cfg.acceptsStatus(res.StatusCode) {
+		return fmt.Errorf("invalid http response code: %d", res.StatusCode)
+	}
+
+	if cfg.Assert != nil {
+		return cfg.Assert.check(res)
 	}
 
 	return nil
 }
+
+// check validates a health check response against the configured assertions.
+// The returned error names the first assertion that did not match
+func (a assertConfig) check(res *http.Response) error {
+	for k, v := range a.Headers {
+		values, ok := res.Header[http.CanonicalHeaderKey(k)]
+		if !ok {
+			return fmt.Errorf("assert.headers: missing response header: %s", k)
+		}
+
+		// an empty value only asserts the header is present
+		if v != "" && !containsString(values, v) {
+			return fmt.Errorf("assert.headers: response header %s: expected: %q, got: %q", k, v, strings.Join(values, ", "))
+		}
+	}
+
+	// only read the body if an assertion requires it, limited to a maximum size
+	if a.BodyContains == "" && a.BodyRegex.Regexp == nil && a.JSON == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, a.BodyLimit))
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if a.BodyContains != "" && !bytes.Contains(body, []byte(a.BodyContains)) {
+		return fmt.Errorf("assert.body_contains: response body does not contain: %q", a.BodyContains)
+	}
+
+	if a.BodyRegex.Regexp != nil && !a.BodyRegex.Match(body) {
+		return fmt.Errorf("assert.body_regex: response body does not match: %s", a.BodyRegex)
+	}
+
+	if a.JSON != nil {
+		// numbers are kept as written, so large integers are not compared
+		// in exponent form
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return fmt.Errorf("assert.json: invalid json response body: %w", err)
+		}
+		if dec.More() {
+			return fmt.Errorf("assert.json: invalid json response body: unexpected data after top-level value")
+		}
+
+		field, ok := jsonField(v, a.JSON.Field)
+		if !ok {
+			return fmt.Errorf("assert.json: missing field: %s", a.JSON.Field)
+		}
+
+		if got := fmt.Sprint(field); got != a.JSON.Value {
+			return fmt.Errorf("assert.json: field %s: expected: %q, got: %q", a.JSON.Field, a.JSON.Value, got)
+		}
+	}
+
+	return nil
+}
+
+// jsonField walks a decoded json value using a dot separated path, where
+// numeric parts index into arrays, e.g: checks.0.status
+func jsonField(v interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			field, ok := t[part]
+			if !ok {
+				return nil, false
+			}
+			v = field
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// urlHost formats an ip addr as the host of a url, ipv6 addrs must be bracketed
+func urlHost(ip net.IP) string {
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+
+	return ip.String()
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type mockDoer struct {
@@ -50,9 +56,12 @@ func TestEnsureHostHealthChecks(t *testing.T) {
 		err: false,
 	}
 
+	var cfg healthCheckConfig
+	cfg.setDefaults()
+
 	for name, test := range testTable {
 		t.Run(name, func(t *testing.T) {
-			err := ensureHostHealthChecks(test, net.ParseIP("192.168.0.1"), "syscll.org")
+			err := ensureHostHealthChecks(context.Background(), test, net.ParseIP("192.168.0.1"), "syscll.org", cfg)
 			if test.err && err == nil {
 				t.Errorf("expected error, got: nil")
 			}
@@ -62,3 +71,262 @@ func TestEnsureHostHealthChecks(t *testing.T) {
 		})
 	}
 }
+
+func TestEnsureHostHealthChecksConfig(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		cfg     healthCheckConfig
+		ip      string
+		status  int
+		err     bool
+		checkFn func(*testing.T, *http.Request)
+	}{
+		"TestIPv6URL": {
+			cfg: healthCheckConfig{
+				Path:    "/healthz",
+				Schemes: []string{"http"},
+			},
+			ip:     "2001:db8::1",
+			status: http.StatusOK,
+			checkFn: func(t *testing.T, req *http.Request) {
+				if req.URL.String() != "http://[2001:db8::1]/healthz" {
+					t.Errorf("unexpected url: %s", req.URL)
+				}
+				if req.URL.Hostname() != "2001:db8::1" {
+					t.Errorf("unexpected url host: %s", req.URL.Hostname())
+				}
+				if req.Host != "syscll.org" {
+					t.Errorf("unexpected host: %s", req.Host)
+				}
+			},
+		},
+		"TestCustomRequest": {
+			cfg: healthCheckConfig{
+				Path:    "/healthz?full=1",
+				Method:  http.MethodHead,
+				Headers: map[string]string{"X-Check": "ingressd"},
+				Schemes: []string{"https"},
+			},
+			status: http.StatusOK,
+			checkFn: func(t *testing.T, req *http.Request) {
+				if req.URL.String() != "https://192.168.0.1/healthz?full=1" {
+					t.Errorf("unexpected url: %s", req.URL)
+				}
+				if req.Method != http.MethodHead {
+					t.Errorf("unexpected method: %s", req.Method)
+				}
+				if req.Header.Get("X-Check") != "ingressd" {
+					t.Errorf("missing header: X-Check")
+				}
+				if req.Host != "syscll.org" {
+					t.Errorf("unexpected host: %s", req.Host)
+				}
+			},
+		},
+		"TestHostHeaderOverride": {
+			cfg: healthCheckConfig{
+				Headers: map[string]string{"host": "status.syscll.org"},
+			},
+			status: http.StatusOK,
+			checkFn: func(t *testing.T, req *http.Request) {
+				if req.Host != "status.syscll.org" {
+					t.Errorf("unexpected host: %s", req.Host)
+				}
+			},
+		},
+		"TestStatusRangeSuccess": {
+			cfg: healthCheckConfig{
+				StatusCodes: []statusRange{{Min: 200, Max: 299}, {Min: 301, Max: 301}},
+			},
+			status: http.StatusMovedPermanently,
+		},
+		"TestStatusRangeError": {
+			cfg: healthCheckConfig{
+				StatusCodes: []statusRange{{Min: 200, Max: 299}},
+			},
+			status: http.StatusFound,
+			err:    true,
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test.cfg.setDefaults()
+
+			var mu sync.Mutex
+			var reqs []*http.Request
+			doer := mockDoer{
+				doFunc: func(req *http.Request) (*http.Response, error) {
+					mu.Lock()
+					reqs = append(reqs, req)
+					mu.Unlock()
+
+					return &http.Response{
+						Body:       ioutil.NopCloser(nil),
+						StatusCode: test.status,
+					}, nil
+				},
+			}
+
+			ip := test.ip
+			if ip == "" {
+				ip = "192.168.0.1"
+			}
+
+			err := ensureHostHealthChecks(context.Background(), doer, net.ParseIP(ip), "syscll.org", test.cfg)
+			if test.err && err == nil {
+				t.Errorf("expected error, got: nil")
+			}
+			if !test.err && err != nil {
+				t.Errorf("expected error: nil, got: %v", err)
+			}
+
+			if expected := test.cfg.Attempts * len(test.cfg.Schemes); len(reqs) != expected {
+				t.Errorf("expected %d requests, got: %d", expected, len(reqs))
+			}
+
+			if test.checkFn != nil {
+				for _, req := range reqs {
+					test.checkFn(t, req)
+				}
+			}
+		})
+	}
+}
+
+func TestAssertCheck(t *testing.T) {
+	t.Parallel()
+
+	body := `{"status": "ok", "build": 1234567, "load": 0.75, "checks": [{"name": "db", "healthy": true}]}`
+
+	testTable := map[string]struct {
+		assert assertConfig
+		err    string
+	}{
+		"TestBodyContainsSuccess": {
+			assert: assertConfig{BodyContains: `"status": "ok"`},
+		},
+		"TestBodyContainsError": {
+			assert: assertConfig{BodyContains: "maintenance"},
+			err:    `assert.body_contains: response body does not contain: "maintenance"`,
+		},
+		"TestBodyContainsLimitError": {
+			assert: assertConfig{BodyContains: "healthy", BodyLimit: 10},
+			err:    `assert.body_contains: response body does not contain: "healthy"`,
+		},
+		"TestBodyRegexSuccess": {
+			assert: assertConfig{BodyRegex: pattern{regexp.MustCompile(`"status":\s*"ok"`)}},
+		},
+		"TestBodyRegexError": {
+			assert: assertConfig{BodyRegex: pattern{regexp.MustCompile(`^ok$`)}},
+			err:    "assert.body_regex: response body does not match: ^ok$",
+		},
+		"TestHeaderPresentSuccess": {
+			assert: assertConfig{Headers: map[string]string{"x-backend": ""}},
+		},
+		"TestHeaderValueSuccess": {
+			assert: assertConfig{Headers: map[string]string{"Content-Type": "application/json"}},
+		},
+		"TestHeaderMissingError": {
+			assert: assertConfig{Headers: map[string]string{"X-Version": ""}},
+			err:    "assert.headers: missing response header: X-Version",
+		},
+		"TestHeaderValueError": {
+			assert: assertConfig{Headers: map[string]string{"X-Backend": "ingress-1"}},
+			err:    `assert.headers: response header X-Backend: expected: "ingress-1", got: "default-backend"`,
+		},
+		"TestJSONSuccess": {
+			assert: assertConfig{JSON: &jsonAssertConfig{Field: "checks.0.healthy", Value: "true"}},
+		},
+		"TestJSONValueError": {
+			assert: assertConfig{JSON: &jsonAssertConfig{Field: "status", Value: "healthy"}},
+			err:    `assert.json: field status: expected: "healthy", got: "ok"`,
+		},
+		"TestJSONIntegerSuccess": {
+			assert: assertConfig{JSON: &jsonAssertConfig{Field: "build", Value: "1234567"}},
+		},
+		"TestJSONDecimalSuccess": {
+			assert: assertConfig{JSON: &jsonAssertConfig{Field: "load", Value: "0.75"}},
+		},
+		"TestJSONMissingFieldError": {
+			assert: assertConfig{JSON: &jsonAssertConfig{Field: "checks.1.healthy", Value: "true"}},
+			err:    "assert.json: missing field: checks.1.healthy",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if test.assert.BodyLimit == 0 {
+				test.assert.BodyLimit = defaultAssertBodyLimit
+			}
+
+			res := &http.Response{
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Content-Type": []string{"application/json"},
+					"X-Backend":    []string{"default-backend"},
+				},
+			}
+
+			err := test.assert.check(res)
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+			}
+			if test.err == "" && err != nil {
+				t.Errorf("expected error: nil, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestEnsureHostHealthChecksCancelled(t *testing.T) {
+	t.Parallel()
+
+	cfg := healthCheckConfig{Attempts: 1, Schemes: []string{"http"}}
+	cfg.setDefaults()
+
+	// requests are bound by the context of the caller
+	doer := mockDoer{
+		doFunc: func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := ensureHostHealthChecks(ctx, doer, net.ParseIP("192.168.0.1"), "syscll.org", cfg)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error: %v, got: %v", context.Canceled, err)
+	}
+}
+
+func TestCheckHostTimeout(t *testing.T) {
+	t.Parallel()
+
+	cfg := healthCheckConfig{Attempts: 1, Schemes: []string{"http"}, Timeout: duration{time.Minute}}
+	cfg.setDefaults()
+
+	// the request deadline is the configured timeout, not that of the client
+	doer := mockDoer{
+		doFunc: func(req *http.Request) (*http.Response, error) {
+			deadline, ok := req.Context().Deadline()
+			if !ok || time.Until(deadline) <= 10*time.Second {
+				return nil, fmt.Errorf("unexpected deadline: %s", deadline)
+			}
+			return &http.Response{Body: ioutil.NopCloser(strings.NewReader("")), StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	if err := ensureHostHealthChecks(context.Background(), doer, net.ParseIP("192.168.0.1"), "syscll.org", cfg); err != nil {
+		t.Errorf("expected error: nil, got: %v", err)
+	}
+	if httpClient.Timeout != 0 {
+		t.Errorf("expected no client timeout, got: %s", httpClient.Timeout)
+	}
+}
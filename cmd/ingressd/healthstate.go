@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// Prometheus gauge for storing the current health state of each record ip addr
+	ipHealthState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ingressd_ip_healthy",
+		Help: "Current health state of a record ip addr, 1 if healthy",
+	}, []string{"record", "ip"})
+
+	// Prometheus counter for storing the number of health state transitions
+	ipHealthTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ingressd_ip_health_transitions_total",
+		Help: "Total number of record ip addr health state transitions",
+	}, []string{"record", "state"})
+)
+
+// healthKey uniquely identifies a tracked record ip addr
+type healthKey struct {
+	record string
+	ip     string
+}
+
+// healthState is the current health state of a single record ip addr, along
+// with the number of consecutive results that disagree with it
+type healthState struct {
+	healthy bool
+
+	// number of consecutive results opposing the current state
+	count int
+}
+
+// healthTracker keeps an in-memory health state for each record ip addr, only
+// changing state after a configured number of consecutive results
+type healthTracker struct {
+	mu     sync.Mutex
+	states map[healthKey]*healthState
+}
+
+// newHealthTracker creates an empty health tracker
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		states: make(map[healthKey]*healthState),
+	}
+}
+
+// observe records a health check result for a given record ip addr and returns
+// its resulting state. Untracked ip addrs start unhealthy, so MUST pass rise
+// consecutive checks before being considered healthy. Healthy ip addrs MUST fail
+// fall consecutive checks before being considered unhealthy
+func (t *healthTracker) observe(record string, ip net.IP, passed bool, rise, fall int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := healthKey{record: record, ip: ip.String()}
+	state, ok := t.states[key]
+	if !ok {
+		state = &healthState{}
+		t.states[key] = state
+	}
+
+	// a result matching the current state resets the opposing count
+	if passed == state.healthy {
+		state.count = 0
+	} else {
+		state.count++
+
+		threshold := rise
+		if state.healthy {
+			threshold = fall
+		}
+
+		if state.count >= threshold {
+			state.healthy = passed
+			state.count = 0
+
+			s := "unhealthy"
+			if passed {
+				s = "healthy"
+			}
+			ipHealthTransitions.WithLabelValues(record, s).Inc()
+			log.Info().Str("record", record).IPAddr("ip", ip).Msgf("ip addr is now %s", s)
+		}
+	}
+
+	v := 0.0
+	if state.healthy {
+		v = 1
+	}
+	ipHealthState.WithLabelValues(record, key.ip).Set(v)
+
+	return state.healthy
+}
+
+// prune removes the state of any ip addrs for a given record that are not in
+// the given list, so they start from scratch if discovered again
+func (t *healthTracker) prune(record string, ips []net.IP) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keep := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		keep[ip.String()] = true
+	}
+
+	for key := range t.states {
+		if key.record == record && !keep[key.ip] {
+			delete(t.states, key)
+			ipHealthState.DeleteLabelValues(key.record, key.ip)
+		}
+	}
+}
+
+// healthResults shares the health of each record ip addr within a single poll,
+// so that ip addrs published in several record sets, such as the default
+// geolocation record set, are only checked and observed once
+type healthResults struct {
+	mu      sync.Mutex
+	results map[healthKey]*healthResult
+}
+
+// healthResult is the health of a record ip addr, known once done is closed
+type healthResult struct {
+	done    chan struct{}
+	healthy bool
+	err     error
+}
+
+// newHealthResults creates an empty set of health results
+func newHealthResults() *healthResults {
+	return &healthResults{
+		results: make(map[healthKey]*healthResult),
+	}
+}
+
+// get returns the health of a given record ip addr. The first caller obtains
+// it using check, any other caller waits for its result
+func (r *healthResults) get(record string, ip net.IP, check func() (bool, error)) (bool, error) {
+	key := healthKey{record: record, ip: ip.String()}
+
+	r.mu.Lock()
+	res, ok := r.results[key]
+	if !ok {
+		res = &healthResult{done: make(chan struct{})}
+		r.results[key] = res
+	}
+	r.mu.Unlock()
+
+	if ok {
+		<-res.done
+		return res.healthy, res.err
+	}
+
+	res.healthy, res.err = check()
+	close(res.done)
+
+	return res.healthy, res.err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestHealthTrackerObserve(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		rise     int
+		fall     int
+		results  []bool
+		expected []bool
+	}{
+		"TestRiseFromUntracked": {
+			rise:     2,
+			fall:     3,
+			results:  []bool{true, true, true},
+			expected: []bool{false, true, true},
+		},
+		"TestRiseResetByFailure": {
+			rise:     2,
+			fall:     3,
+			results:  []bool{true, false, true, true},
+			expected: []bool{false, false, false, true},
+		},
+		"TestFallAfterConsecutiveFailures": {
+			rise:     1,
+			fall:     3,
+			results:  []bool{true, false, false, false, false},
+			expected: []bool{true, true, true, false, false},
+		},
+		"TestFallResetBySuccess": {
+			rise:     1,
+			fall:     2,
+			results:  []bool{true, false, true, false, true},
+			expected: []bool{true, true, true, true, true},
+		},
+		"TestFlapping": {
+			rise:     2,
+			fall:     2,
+			results:  []bool{true, true, false, true, false, false, true, false},
+			expected: []bool{false, true, true, true, true, false, false, false},
+		},
+		"TestNoHysteresis": {
+			rise:     1,
+			fall:     1,
+			results:  []bool{false, true, false, true},
+			expected: []bool{false, true, false, true},
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			tracker := newHealthTracker()
+			ip := net.ParseIP("192.168.0.1")
+
+			for i, passed := range test.results {
+				if healthy := tracker.observe("syscll.org", ip, passed, test.rise, test.fall); healthy != test.expected[i] {
+					t.Fatalf("result %d: expected healthy: %t, got: %t", i, test.expected[i], healthy)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthTrackerPrune(t *testing.T) {
+	t.Parallel()
+
+	tracker := newHealthTracker()
+	ip1 := net.ParseIP("192.168.0.1")
+	ip2 := net.ParseIP("192.168.0.2")
+
+	tracker.observe("syscll.org", ip1, true, 1, 1)
+	tracker.observe("syscll.org", ip2, true, 1, 1)
+	tracker.observe("ingress.syscll.org", ip2, true, 1, 1)
+
+	// ip2 is no longer discovered for syscll.org only
+	tracker.prune("syscll.org", []net.IP{ip1})
+
+	if _, ok := tracker.states[healthKey{"syscll.org", ip1.String()}]; !ok {
+		t.Errorf("expected state for syscll.org/%s", ip1)
+	}
+	if _, ok := tracker.states[healthKey{"syscll.org", ip2.String()}]; ok {
+		t.Errorf("expected no state for syscll.org/%s", ip2)
+	}
+	if _, ok := tracker.states[healthKey{"ingress.syscll.org", ip2.String()}]; !ok {
+		t.Errorf("expected state for ingress.syscll.org/%s", ip2)
+	}
+
+	// a pruned ip addr must rise again from scratch
+	if tracker.observe("syscll.org", ip2, true, 2, 1) {
+		t.Errorf("expected pruned ip addr to be unhealthy")
+	}
+}
+
+func TestHealthResults(t *testing.T) {
+	t.Parallel()
+
+	results := newHealthResults()
+	ip := net.ParseIP("192.168.0.1")
+
+	var mu sync.Mutex
+	var checks int
+	check := func() (bool, error) {
+		mu.Lock()
+		checks++
+		mu.Unlock()
+		return true, nil
+	}
+
+	// record sets sharing an ip addr get the same result from a single check
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if healthy, err := results.get("syscll.org", ip, check); !healthy || err != nil {
+				t.Errorf("expected healthy: true, got: %t, %v", healthy, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	results.get("ingress.syscll.org", ip, check)
+
+	if checks != 2 {
+		t.Errorf("expected a check per record ip addr, got: %d", checks)
+	}
+}
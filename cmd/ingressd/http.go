@@ -11,8 +11,9 @@ import (
 )
 
 // startHTTP creates and starts a local webserver used to expose a health
-// check and prometheus metrics
-func startHTTP(port int) *http.Server {
+// check and prometheus metrics. The health check fails while healthy returns
+// an error, such as when aws credentials cannot be retrieved
+func startHTTP(port int, healthy func() error) *http.Server {
 	// configure http server with 10s timeoutes
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
@@ -22,12 +23,17 @@ func startHTTP(port int) *http.Server {
 
 	// configure a health check handler
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := healthy(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 
 	// register and configure a prometheus metrics handler
-	prometheus.MustRegister(healthCheckFailures)
+	prometheus.MustRegister(healthCheckFailures, ipHealthState, ipHealthTransitions, safetyGuardActive, recordChanges, zoneCacheRequests, canaryWeight)
 	http.Handle("/metrics", promhttp.Handler())
 
 	// we don't care about errors from the server as the caller of the health check
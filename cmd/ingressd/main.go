@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"net"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
@@ -31,42 +35,63 @@ const (
 	envPort = "PORT"
 )
 
+// how long in-flight route53 changes and the local http server are given to
+// finish once a stop signal is received
+const shutdownTimeout = 10 * time.Second
+
+// Prometheus counter for storing the number of skipped, applied and failed record changes
+var recordChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingressd_record_changes_total",
+	Help: "Total number of route53 record set changes by result: skipped, applied or failed",
+}, []string{"record", "type", "result"})
+
 func main() {
-	// parse valid aws ec2 tag into parts, tag[0] == key, tag[1] == value
-	tag := strings.SplitN(os.Getenv(envAWSEC2Tag), ":", 2)
-	if len(tag) != 2 {
-		log.Fatal().Msgf("invalid aws ec2 tag: %s", envAWSEC2Tag)
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-
-	// parse aws region
-	region := os.Getenv(envAWSRegion)
-	if region == "" {
-		log.Fatal().Msgf("missing aws region: %s", envAWSRegion)
+	if err != nil {
+		log.Fatal().Err(err).Msg("error parsing arguments")
 	}
 
-	// parse route53 records
-	records := strings.Split(os.Getenv(envAWSRoute53Records), ",")
-	if len(records) == 0 {
-		log.Fatal().Msgf("missing aws route53 records: %s", envAWSRoute53Records)
+	// load config from the given file, falling back to environment variables
+	var cfg config
+	if opts.configPath != "" {
+		cfg, err = loadConfig(opts.configPath)
+	} else {
+		cfg, err = configFromEnv()
 	}
-
-	// parse poll interval
-	p := os.Getenv(envPollInterval)
-	if p == "" {
-		p = "30s"
+	if err != nil {
+		log.Fatal().Err(err).Msg("error loading config")
 	}
-	interval, err := time.ParseDuration(p)
+
+	// discoverers are created once, so sources can keep state between polls.
+	// route53 hosted zones are cached between polls
+	d, err := newDiscovery(cfg)
 	if err != nil {
-		log.Fatal().Msgf("invalid poll interval: %s: %v", interval, err)
+		log.Fatal().Err(err).Msg("error creating sources")
 	}
 
-	// parse port
-	port := 8081
-	if p := os.Getenv(envPort); p != "" {
-		port, err = strconv.Atoi(p)
-		if err != nil {
-			log.Fatal().Msgf("invalid port: %s: %v", p, err)
+	// a plan is a single dry-run poll, where each ip addr's health is decided
+	// by a single round of health checks
+	if opts.plan {
+		for i := range cfg.Records {
+			cfg.Records[i].HealthCheck.Rise = 1
 		}
+
+		// a stop signal cancels the plan instead of waiting for slow health checks
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		plans := poll(ctx, ctx, cfg, d, httpClient, newHealthTracker(), true)
+		if err := ctx.Err(); err != nil {
+			log.Fatal().Err(err).Msg("received stop signal, plan is incomplete")
+		}
+
+		if err := writePlans(os.Stdout, plans, opts.output); err != nil {
+			log.Fatal().Err(err).Msg("error writing plan")
+		}
+		return
 	}
 
 	// configure a channel to listen for exit signals in order to perform
@@ -74,95 +99,547 @@ func main() {
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
+	// new work, such as discovery, health checks and planning, is cancelled as
+	// soon as a stop signal is received
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// route53 changes that have already started are given until the shutdown
+	// deadline to finish, so that records are not left half written
+	changeCtx, cancelChanges := context.WithCancel(context.Background())
+
+	go func() {
+		<-stop
+		log.Info().Msg("received stop signal, attempting graceful shutdown")
+
+		cancel()
+		time.AfterFunc(shutdownTimeout, cancelChanges)
+	}()
+
 	// start the local http server
-	srv := startHTTP(port)
+	srv := startHTTP(cfg.Port, d.credentials.err)
+
+	// health state of each record ip addr is kept between polls
+	tracker := newHealthTracker()
+
+	// refresh the hosted zone caches in the background
+	for _, zones := range d.zones {
+		go zones.run(ctx)
+	}
+
+	// the records of sources that change, such as files, or that discovered an
+	// instance that changed state, are polled as soon as they change
+	changes := newChangeSet()
+	d.watch(ctx.Done(), changes)
 
 	// start a ticker at given intervals
-	t := time.NewTicker(interval)
-	log.Info().Msgf("service started, will attempt to assign ingress service ip addresses every %s", interval)
+	t := time.NewTicker(cfg.PollInterval.Duration)
+	log.Info().Msgf("service started, will attempt to assign ingress service ip addresses every %s", cfg.PollInterval)
+	if opts.dryRun {
+		log.Info().Msg("dry-run enabled, route53 changes will be printed instead of applied")
+	}
+
+	reconcile := func(cfg config) {
+		// a tick or change may be selected after a stop signal
+		if ctx.Err() != nil {
+			return
+		}
+
+		plans := poll(ctx, changeCtx, cfg, d, httpClient, tracker, opts.dryRun)
+
+		if opts.dryRun {
+			if err := writePlans(os.Stdout, plans, opts.output); err != nil {
+				log.Error().Err(err).Msg("error writing plan")
+			}
+		}
+	}
 
 	for {
 		select {
-		case <-stop:
-			log.Info().Msg("received stop signal, attempting graceful shutdown")
-
-			// stop ticker
+		case <-ctx.Done():
+			// any poll in progress has returned, along with its changes
 			t.Stop()
+			cancelChanges()
 
 			// gracefully shutdown
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
 
-			if err := srv.Shutdown(ctx); err != nil {
-				cancel()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				cancelShutdown()
 				log.Fatal().Err(err).Msg("error shuting down http server")
 			}
 
-			cancel()
+			cancelShutdown()
 			os.Exit(0)
 		case <-t.C:
-			poll(region, tag, records)
+			// every record is periodically resynced, regardless of changes
+			reconcile(cfg)
+		case <-changes.ready():
+			sources := changes.take()
+			if len(sources) == 0 {
+				continue
+			}
+
+			log.Info().Strs("sources", sources).Msg("sources changed, polling their records early")
+			reconcile(cfg.only(sources))
 		}
 	}
 }
 
-// poll periodically attempts to retrieve the public ip addrs of a set of ec2 instances
-// and ensure the provided route53 record sets are configured
-func poll(region string, tag []string, records []string) {
-	// configure aws service manager
-	aws := newAWSManager(region)
+// options are the command line options of the service
+type options struct {
+	// path to a yaml or json config file, overrides environment variables
+	configPath string
 
-	// get all public ip addrs of ec2 instances with given tag
-	ips, err := aws.getTaggedEC2PublicIPAddrs(tag[0], tag[1])
-	if err != nil {
-		log.Error().Err(err).Msg("error getting public ip addrs")
-		return
+	// print intended route53 changes on each poll instead of applying them
+	dryRun bool
+
+	// format of dry-run and plan output: text or json
+	output string
+
+	// print the plan of a single poll and exit
+	plan bool
+}
+
+// parseFlags parses the command line arguments of the service. The plan
+// subcommand may be given before, after or between any flags
+func parseFlags(args []string) (options, error) {
+	var opts options
+
+	fs := flag.NewFlagSet("ingressd", flag.ContinueOnError)
+	fs.StringVar(&opts.configPath, "config", "", "path to a yaml or json config file, overrides environment variables")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print intended route53 changes on each poll instead of applying them")
+	fs.StringVar(&opts.output, "output", planOutputText, "format of dry-run and plan output: text or json")
+
+	if err := fs.Parse(args); err != nil {
+		return opts, err
 	}
 
-	if len(ips) == 0 {
-		log.Error().Msg("no ip addrs found, will not update")
-		return
+	// parsing stops at the subcommand, so any flags after it are parsed again
+	if fs.Arg(0) == "plan" {
+		opts.plan = true
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return opts, err
+		}
+	}
+
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unknown command: %s", fs.Arg(0))
+	}
+
+	if opts.output != planOutputText && opts.output != planOutputJSON {
+		return opts, fmt.Errorf("invalid output format: %s", opts.output)
+	}
+
+	return opts, nil
+}
+
+// poll periodically attempts to retrieve the ip addrs of each configured
+// source and ensure the provided route53 record sets are configured. The plan of
+// each record set is returned in config order. If dryRun is set, no changes are applied.
+// Discovery, health checks and planning are bound by ctx and the poll timeout,
+// and the changes of record sets planned in time are applied. No changes are
+// started once ctx is done, changes that have started are bound by changeCtx
+// instead. Each ip addr is health checked using the given http client
+func poll(ctx, changeCtx context.Context, cfg config, d *discovery, client httpDoer, tracker *healthTracker, dryRun bool) []recordPlan {
+	// discovery, health checks and planning are bound by the poll timeout
+	pollCtx, cancel := context.WithTimeout(ctx, cfg.PollTimeout.Duration)
+	defer cancel()
+
+	// credentials are checked on every poll, refreshing any that have expired,
+	// so that credential errors are reported by the health check
+	d.credentials.check(pollCtx)
+
+	// every instance draining by now is excluded from the targets below
+	drained := d.draining.mark()
+
+	// discover the targets of each source, keeping the instances discovered
+	// by each source and its records
+	sourceTargets := make(map[string][]target)
+	observed := make(map[string][]target)
+	for _, src := range cfg.Sources {
+		targets, err := d.sources[src.Name].Discover(pollCtx)
+		if err != nil {
+			log.Error().Err(err).Str("source", src.Name).Msg("error discovering targets")
+			continue
+		}
+
+		log.Info().Str("source", src.Name).Msgf("found %d ip addrs", len(targets))
+		sourceTargets[src.Name] = d.draining.filter(targets)
+		observed[src.Name] = targets
+	}
+
+	sources := make(map[string]sourceConfig, len(cfg.Sources))
+	for _, src := range cfg.Sources {
+		sources[src.Name] = src
+	}
+
+	// weights that shift over time are calculated once per poll
+	now := time.Now()
+
+	// split each record into its record sets, A and/or AAAA and one per region
+	// for routing policies, each with the discovered targets of its type and region
+	var sets []recordSet
+	for _, record := range cfg.Records {
+		targets := sourceTargets[record.Source]
+
+		// records with their own selector discover their own targets
+		if disc, ok := d.records[record.Name]; ok {
+			var err error
+			targets, err = disc.Discover(pollCtx)
+			if err != nil {
+				log.Error().Err(err).Str("record", record.Name).Str("source", record.Source).Msg("error discovering targets")
+			}
+			if _, ok := observed[record.Source]; ok {
+				observed[record.Source] = append(observed[record.Source], targets...)
+			}
+			targets = d.draining.filter(targets)
+		}
+
+		// weighted records also discover their canary pool. Without it, the
+		// primary pool is unknown too, so neither record set is updated
+		var canaries []target
+		var canaryErr error
+		if disc, ok := d.canaries[record.Name]; ok {
+			canaries, canaryErr = disc.Discover(pollCtx)
+			if canaryErr != nil {
+				log.Error().Err(canaryErr).Str("record", record.Name).Str("source", record.Source).Msg("error discovering canary targets, will not update")
+				canaryErr = fmt.Errorf("error discovering canary targets: %w", canaryErr)
+			}
+			if _, ok := observed[record.Source]; ok {
+				observed[record.Source] = append(observed[record.Source], canaries...)
+			}
+			canaries = d.draining.filter(canaries)
+		}
+
+		// forget the state of any ip addrs that are no longer discovered, unless
+		// discovery failed
+		if all := append(append([]target{}, targets...), canaries...); len(all) > 0 && canaryErr == nil {
+			tracker.prune(record.Name, targetIPs(all))
+		}
+
+		for _, rrType := range record.DNS.rrTypes() {
+			for _, routing := range record.Routing.recordSets(sources[record.Source].regions(), now) {
+				// the primary pool of a weighted record is every target outside the canary pool
+				setTargets := targets
+				switch routing.Pool {
+				case poolPrimary:
+					setTargets = excludeTargets(targets, canaries)
+				case poolCanary:
+					setTargets = canaries
+				}
+
+				sets = append(sets, recordSet{
+					record:      record,
+					credentials: cfg.route53Credentials(record),
+					rrType:      rrType,
+					routing:     routing,
+					targets:     filterTargetsByRegion(filterTargetsByRRType(setTargets, rrType), routing.Region),
+					err:         canaryErr,
+				})
+			}
+		}
+
+		if canary := record.Routing.Canary; canary != nil {
+			canaryWeight.WithLabelValues(record.Name).Set(float64(canary.weight(now)))
+		}
+
+		// reset the health check gauge of the record before attempting to
+		// perform current health checks, leaving records outside this poll as is
+		healthCheckFailures.WithLabelValues(record.Name).Set(0)
+	}
+
+	for name, targets := range observed {
+		d.observe(name, targets)
 	}
 
-	log.Info().Msgf("found %d ip addrs", len(ips))
+	// ip addrs in several record sets of a record are only checked once
+	results := newHealthResults()
+
+	// bounds the number of ip addrs checked at once, however many are discovered
+	sem := make(chan struct{}, cfg.HealthCheckConcurrency)
+
+	plans := make([]recordPlan, len(sets))
 
-	// reset the health check gauge before attempting to perform
-	// current health checks
-	healthCheckFailures.Set(0)
+	// desired change of each record set, nil if the record set will not be changed
+	changes := make([]*route53.Change, len(sets))
 
 	var wg sync.WaitGroup
 
-	// attempt to upsert record set with given ip addrs
-	for _, record := range records {
+	// determine the desired state of each record set with given ip addrs
+	for i, set := range sets {
+		record := set.record
+		if set.err != nil {
+			plans[i] = set.errorPlan(set.err.Error())
+			continue
+		}
+
+		if len(set.targets) == 0 {
+			log.Error().Str("record", record.Name).Str("type", set.rrType).Str("set_identifier", set.routing.SetIdentifier).Str("source", record.Source).Msg("no ip addrs found, will not update")
+			plans[i] = set.errorPlan("no ip addrs found")
+			continue
+		}
+
 		wg.Add(1)
-		go func(record string) {
+		go func(i int, set recordSet, mgr awsManager) {
 			defer wg.Done()
 
+			record := set.record
+
+			// for each ip addr, concurrently perform health checks to ensure the ip addr
+			// successfully handles a request to the host record. the result is tracked
+			// so ip addrs only change state after consecutive rise/fall results
+			passed := make([]bool, len(set.targets))
+			errs := make([]error, len(set.targets))
+
+			var checks sync.WaitGroup
+			for j, t := range set.targets {
+				checks.Add(1)
+				go func(j int, t target) {
+					defer checks.Done()
+
+					passed[j], errs[j] = results.get(record.Name, t.IP, func() (bool, error) {
+						select {
+						case sem <- struct{}{}:
+							defer func() { <-sem }()
+						case <-pollCtx.Done():
+							return false, pollCtx.Err()
+						}
+
+						err := ensureHostHealthChecks(pollCtx, client, t.IP, record.Name, record.HealthCheck)
+
+						// checks cut short by cancellation or the poll timeout say
+						// nothing about the health of the ip addr
+						if pollCtx.Err() != nil {
+							return false, pollCtx.Err()
+						}
+
+						if err != nil {
+							log.Error().Err(err).IPAddr("ip", t.IP).Str("instance.id", t.InstanceID).Str("region", t.Region).Str("record", record.Name).Msg("failed health checks")
+						}
+
+						return tracker.observe(record.Name, t.IP, err == nil, record.HealthCheck.Rise, record.HealthCheck.Fall), nil
+					})
+				}(j, t)
+			}
+
+			checks.Wait()
+
 			var healthy []net.IP
+			for j, t := range set.targets {
+				if errs[j] != nil {
+					plans[i] = set.errorPlan(errs[j].Error())
+					return
+				}
 
-			// for each ip addr, perform health checks to ensure the ip addr successfully
-			// handles a request to the host record
-			for _, ip := range ips {
-				if err := ensureHostHealthChecks(httpClient, ip, record); err != nil {
-					log.Error().Err(err).IPAddr("ip", ip).Str("record", record).Msg("failed all health checks, will not add this record")
-					continue
+				if passed[j] {
+					healthy = append(healthy, t.IP)
 				}
-				healthy = append(healthy, ip)
 			}
 
-			if len(healthy) == 0 {
-				log.Error().Str("record", record).Msg("all health checks failed, will not update")
+			// compare the current record set against the healthy ip addrs
+			plan, err := mgr.planRoute53RecordSet(pollCtx, record.Name, set.rrType, set.routing, record.DNS, healthy)
+			plans[i] = plan
+			if err != nil {
+				log.Error().Err(err).Str("record", record.Name).Str("type", set.rrType).Str("set_identifier", set.routing.SetIdentifier).Msg("error planning record set, will not update")
+				plans[i].Error = err.Error()
 				return
 			}
 
-			if err := aws.ensureRoute53RecordSet(record, healthy); err != nil {
-				log.Error().Err(err).Str("record", record).Msg("error performing change on resource record")
+			// keep the current record set if too few ip addrs are healthy
+			if err := ensureSafeRecordSet(record, set.rrType, len(healthy), len(set.targets)); err != nil {
+				log.Error().Err(err).Str("record", record.Name).Str("type", set.rrType).Msg("safety guard active, will not update")
+				plans[i].Error = err.Error()
 				return
 			}
 
-			log.Info().Str("record", record).Int("ip_addrs", len(healthy)).Msg("successfully updated record with healthy ip addrs")
-		}(record)
+			if dryRun {
+				return
+			}
+
+			// avoid spending api quota on changes that would not modify the record set
+			if !plan.Changed {
+				recordChanges.WithLabelValues(record.Name, set.rrType, "skipped").Inc()
+				log.Debug().Str("record", record.Name).Str("type", set.rrType).Msg("record set is up to date, skipping change")
+				return
+			}
+
+			changes[i] = newRoute53Change(record.Name, set.rrType, set.routing, healthy, plan.DesiredTTL)
+		}(i, set, d.route53[record.Name])
 	}
 
 	wg.Wait()
-	log.Info().Msg("all records are up to date")
+
+	holdWeights(sets, plans, changes)
+
+	if !dryRun {
+		// record sets cut short have no change, and keep their current state
+		if err := pollCtx.Err(); err != nil {
+			log.Error().Err(err).Msg("poll cancelled or timed out, only applying changes planned in time")
+		}
+
+		applyChanges(ctx, changeCtx, d.route53, sets, plans, changes)
+		log.Info().Msg("all records are up to date")
+
+		// records whose every record set is up to date no longer publish any
+		// instance that was draining before this poll
+		failed := make(map[string]bool)
+		for i, set := range sets {
+			if plans[i].Error != "" {
+				failed[set.record.Name] = true
+			}
+		}
+		for _, record := range cfg.Records {
+			if !failed[record.Name] {
+				d.draining.applied(record.Name, drained)
+			}
+		}
+	}
+
+	return plans
+}
+
+// recordSet is a single route53 record set, A or AAAA, of a configured record
+type recordSet struct {
+	record recordConfig
+
+	// name of the credentials managing the hosted zone of the record
+	credentials string
+
+	// type of the record set, A or AAAA
+	rrType string
+
+	// set identifier and routing parameters of the record set
+	routing recordRouting
+
+	// discovered targets of the record set type and region
+	targets []target
+
+	// error preventing the record set from being updated on this poll, if any
+	err error
+}
+
+// errorPlan returns the plan of a record set that will not be updated
+func (s recordSet) errorPlan(reason string) recordPlan {
+	plan := newRecordPlan(s.record.Name, s.rrType, s.routing, aws.Int64Value(s.record.DNS.TTL))
+	plan.Error = reason
+	return plan
+}
+
+// holdWeights keeps the current weights of the weighted record sets of a record
+// and type unless every one of them is updated, so a step of the canary weight
+// is never applied to only one of them. Record sets whose only change is their
+// weight, or that do not exist yet, are not updated at all
+func holdWeights(sets []recordSet, plans []recordPlan, changes []*route53.Change) {
+	// indexes of the weighted record sets of each record and type
+	groups := make(map[string][]int)
+	for i, set := range sets {
+		if set.routing.Weight != nil {
+			key := set.record.Name + "/" + set.rrType
+			groups[key] = append(groups[key], i)
+		}
+	}
+
+	for _, indexes := range groups {
+		updated := true
+		for _, i := range indexes {
+			if plans[i].Error != "" || !plans[i].Changed {
+				updated = false
+			}
+		}
+		if updated {
+			continue
+		}
+
+		for _, i := range indexes {
+			set, plan := sets[i], plans[i]
+			if plan.Error != "" || !plan.Changed || aws.Int64Value(plan.currentWeight) == aws.Int64Value(set.routing.Weight) {
+				continue
+			}
+
+			held := set.routing
+			held.Weight = plan.currentWeight
+
+			if plan.currentWeight == nil || (len(plan.Added) == 0 && len(plan.Removed) == 0 && *plan.CurrentTTL == plan.DesiredTTL) {
+				log.Error().Str("record", set.record.Name).Str("type", set.rrType).Str("set_identifier", set.routing.SetIdentifier).Msg("other weighted record sets are not updated, holding back weight change")
+				plans[i].Error = "weighted record sets are only updated together, holding back weight change"
+				changes[i] = nil
+				continue
+			}
+
+			// the ip addrs of the record set are still updated, at its current weight
+			plans[i].DesiredRouting = held.describe()
+			if changes[i] != nil {
+				ips := make([]net.IP, 0, len(plan.Desired))
+				for _, ip := range plan.Desired {
+					ips = append(ips, net.ParseIP(ip))
+				}
+				changes[i] = newRoute53Change(set.record.Name, set.rrType, held, ips, plan.DesiredTTL)
+			}
+		}
+	}
+}
+
+// applyChanges groups the desired record set changes by hosted zone and the
+// credentials managing it, and applies each group as a single change batch,
+// using the route53 manager of its records by name. A failed change is reported
+// against the plan of its record set. No batch is started once ctx is done,
+// batches that have started are bound by changeCtx instead
+func applyChanges(ctx, changeCtx context.Context, managers map[string]awsManager, sets []recordSet, plans []recordPlan, changes []*route53.Change) {
+	// a zone shared by records of different credentials, such as after moving
+	// the zone to another account, is changed by each of them separately
+	type batchKey struct {
+		credentials string
+		zoneID      string
+	}
+
+	// record set indexes grouped by credentials and hosted zone, in config order
+	var keys []batchKey
+	byKey := make(map[batchKey][]int)
+	for i, change := range changes {
+		if change == nil {
+			continue
+		}
+
+		key := batchKey{credentials: sets[i].credentials, zoneID: plans[i].ZoneID}
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], i)
+	}
+
+	for _, key := range keys {
+		indexes, zoneID := byKey[key], key.zoneID
+
+		// the changes of any remaining zones are dropped after a stop signal
+		if err := ctx.Err(); err != nil {
+			log.Error().Err(err).Str("zone", zoneID).Msgf("poll cancelled, will not apply %d changes", len(indexes))
+			for _, i := range indexes {
+				plans[i].Error = err.Error()
+			}
+			continue
+		}
+
+		batch := make([]*route53.Change, 0, len(indexes))
+		for _, i := range indexes {
+			batch = append(batch, changes[i])
+		}
+
+		// every record of the batch uses the same credentials
+		mgr := managers[sets[indexes[0]].record.Name]
+		errs := mgr.ensureRoute53Changes(changeCtx, zoneID, batch)
+
+		for j, i := range indexes {
+			record, plan := sets[i].record, plans[i]
+
+			if errs[j] != nil {
+				plans[i].Error = errs[j].Error()
+				recordChanges.WithLabelValues(record.Name, plan.Type, "failed").Inc()
+				log.Error().Err(errs[j]).Str("record", record.Name).Str("type", plan.Type).Str("set_identifier", plan.SetIdentifier).Str("zone", zoneID).Msg("error performing change on resource record")
+				continue
+			}
+
+			recordChanges.WithLabelValues(record.Name, plan.Type, "applied").Inc()
+			log.Info().Str("record", record.Name).Str("type", plan.Type).Str("set_identifier", plan.SetIdentifier).Str("zone", zoneID).Int("ip_addrs", len(plan.Desired)).Strs("added", plan.Added).Strs("removed", plan.Removed).Msg("successfully updated record with healthy ip addrs")
+		}
+	}
 }
@@ -0,0 +1,724 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// recordedChanges records the change batches sent to route53 by hosted zone
+type recordedChanges struct {
+	mu      sync.Mutex
+	batches []string
+}
+
+// changeFunc records each change batch as its zone id and the name, set identifier
+// and ip addrs of each change, e.g: zone-1 syscll.org/primary=10.0.0.1
+func (r *recordedChanges) changeFunc(fail map[string]bool) func(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	return func(input *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+		zoneID := aws.StringValue(input.HostedZoneId)
+
+		batch := []string{zoneID}
+		for _, change := range input.ChangeBatch.Changes {
+			batch = append(batch, describeChange(change))
+		}
+
+		r.mu.Lock()
+		r.batches = append(r.batches, strings.Join(batch, " "))
+		r.mu.Unlock()
+
+		if fail[zoneID] {
+			return nil, fmt.Errorf("route53 error")
+		}
+		return &route53.ChangeResourceRecordSetsOutput{}, nil
+	}
+}
+
+// describeChange returns the record name, set identifier, weight and ip addrs of
+// a change, e.g: syscll.org/canary@10=10.0.0.3
+func describeChange(change *route53.Change) string {
+	rrs := change.ResourceRecordSet
+
+	ips := make([]string, 0, len(rrs.ResourceRecords))
+	for _, rr := range rrs.ResourceRecords {
+		ips = append(ips, aws.StringValue(rr.Value))
+	}
+	sort.Strings(ips)
+
+	name := aws.StringValue(rrs.Name)
+	if id := aws.StringValue(rrs.SetIdentifier); id != "" {
+		name += "/" + id
+	}
+	if rrs.Weight != nil {
+		name += fmt.Sprintf("@%d", *rrs.Weight)
+	}
+
+	return name + "=" + strings.Join(ips, ",")
+}
+
+func TestParseFlags(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		args []string
+		opts options
+		err  string
+	}{
+		"TestService": {
+			args: []string{"--config", "c.yaml", "--dry-run"},
+			opts: options{configPath: "c.yaml", dryRun: true, output: planOutputText},
+		},
+		"TestPlanBeforeFlags": {
+			args: []string{"plan", "--config", "c.yaml", "--output", "json"},
+			opts: options{configPath: "c.yaml", output: planOutputJSON, plan: true},
+		},
+		"TestPlanAfterFlags": {
+			args: []string{"--config", "c.yaml", "plan"},
+			opts: options{configPath: "c.yaml", output: planOutputText, plan: true},
+		},
+		"TestPlanBetweenFlags": {
+			args: []string{"--config", "c.yaml", "plan", "--output", "json"},
+			opts: options{configPath: "c.yaml", output: planOutputJSON, plan: true},
+		},
+		"TestUnknownCommandError": {
+			args: []string{"apply"},
+			err:  "unknown command: apply",
+		},
+		"TestExtraArgumentError": {
+			args: []string{"plan", "--output", "json", "syscll.org"},
+			err:  "unknown command: syscll.org",
+		},
+		"TestUnknownFlagError": {
+			args: []string{"plan", "--outptu", "json"},
+			err:  "flag provided but not defined: -outptu",
+		},
+		"TestOutputError": {
+			args: []string{"plan", "--output", "yaml"},
+			err:  "invalid output format: yaml",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			opts, err := parseFlags(test.args)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected error: nil, got: %v", err)
+			}
+			if opts != test.opts {
+				t.Errorf("expected options: %+v, got: %+v", test.opts, opts)
+			}
+		})
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	t.Parallel()
+
+	ips := []net.IP{net.ParseIP("192.168.0.1")}
+	change := func(name string) *route53.Change {
+		return newRoute53Change(name, route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL)
+	}
+
+	// records of two zones, interleaved, with the last record up to date
+	records := []string{"a.syscll.org", "b.example.org", "c.syscll.org", "d.syscll.org"}
+	zones := []string{"zone-1", "zone-2", "zone-1", "zone-1"}
+	changes := []*route53.Change{change(records[0]), change(records[1]), change(records[2]), nil}
+
+	testTable := map[string]struct {
+		fail      map[string]bool
+		cancelled bool
+		batches   []string
+		errs      []bool
+	}{
+		"TestSuccess": {
+			batches: []string{
+				"zone-1 a.syscll.org=192.168.0.1 c.syscll.org=192.168.0.1",
+				"zone-2 b.example.org=192.168.0.1",
+			},
+			errs: []bool{false, false, false, false},
+		},
+		"TestFailedBatch": {
+			fail: map[string]bool{"zone-1": true},
+			batches: []string{
+				"zone-1 a.syscll.org=192.168.0.1 c.syscll.org=192.168.0.1",
+				"zone-2 b.example.org=192.168.0.1",
+			},
+			errs: []bool{true, false, true, false},
+		},
+		"TestCancelled": {
+			cancelled: true,
+			errs:      []bool{true, true, true, false},
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var recorded recordedChanges
+			mgr := awsManager{
+				route53: mockRoute53ReadWriter{
+					changeFunc: recorded.changeFunc(test.fail),
+				},
+			}
+
+			managers := make(map[string]awsManager)
+			sets := make([]recordSet, len(records))
+			plans := make([]recordPlan, len(records))
+			for i, record := range records {
+				managers[record] = mgr
+				sets[i] = recordSet{record: recordConfig{Name: record}, rrType: route53.RRTypeA}
+				plans[i] = recordPlan{Record: record, Type: route53.RRTypeA, ZoneID: zones[i]}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if test.cancelled {
+				cancel()
+			}
+			defer cancel()
+
+			applyChanges(ctx, context.Background(), managers, sets, plans, changes)
+
+			if !reflect.DeepEqual(recorded.batches, test.batches) {
+				t.Errorf("expected batches: %q, got: %q", test.batches, recorded.batches)
+			}
+
+			for i, plan := range plans {
+				if test.errs[i] && plan.Error == "" {
+					t.Errorf("%s: expected error, got: nil", plan.Record)
+				}
+				if !test.errs[i] && plan.Error != "" {
+					t.Errorf("%s: expected error: nil, got: %s", plan.Record, plan.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyChangesCredentials(t *testing.T) {
+	t.Parallel()
+
+	ips := []net.IP{net.ParseIP("192.168.0.1")}
+
+	// records of one zone, the second managed with other credentials
+	records := []string{"a.syscll.org", "b.syscll.org", "c.syscll.org"}
+	credentials := []string{"", "other", ""}
+
+	var defaults, other recordedChanges
+	managers := map[string]awsManager{
+		"a.syscll.org": {route53: mockRoute53ReadWriter{changeFunc: defaults.changeFunc(nil)}},
+		"b.syscll.org": {route53: mockRoute53ReadWriter{changeFunc: other.changeFunc(nil)}},
+		"c.syscll.org": {route53: mockRoute53ReadWriter{changeFunc: defaults.changeFunc(nil)}},
+	}
+
+	sets := make([]recordSet, len(records))
+	plans := make([]recordPlan, len(records))
+	changes := make([]*route53.Change, len(records))
+	for i, record := range records {
+		sets[i] = recordSet{record: recordConfig{Name: record}, credentials: credentials[i], rrType: route53.RRTypeA}
+		plans[i] = recordPlan{Record: record, Type: route53.RRTypeA, ZoneID: "zone-1"}
+		changes[i] = newRoute53Change(record, route53.RRTypeA, recordRouting{}, ips, defaultRecordTTL)
+	}
+
+	applyChanges(context.Background(), context.Background(), managers, sets, plans, changes)
+
+	// each batch is sent with the credentials of its records
+	expected := []string{"zone-1 a.syscll.org=192.168.0.1 c.syscll.org=192.168.0.1"}
+	if !reflect.DeepEqual(defaults.batches, expected) {
+		t.Errorf("expected batches: %q, got: %q", expected, defaults.batches)
+	}
+	expected = []string{"zone-1 b.syscll.org=192.168.0.1"}
+	if !reflect.DeepEqual(other.batches, expected) {
+		t.Errorf("expected batches of other credentials: %q, got: %q", expected, other.batches)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	t.Parallel()
+
+	newTarget := func(ip, region string) target {
+		return target{IP: net.ParseIP(ip), InstanceID: "i-" + ip, Region: region}
+	}
+
+	testTable := map[string]struct {
+		record recordConfig
+
+		// targets of the record's source, and of its canary pool if any
+		targets   []target
+		canaries  []target
+		canaryErr error
+
+		// ip addrs failing every health check
+		failing map[string]bool
+
+		// instance draining before the first poll, if any, and whether it is
+		// removed from the record after the last poll
+		draining string
+		removed  bool
+
+		// current record sets by set identifier, every other record set is empty
+		current map[string]*route53.ResourceRecordSet
+
+		// number of polls, sharing health state
+		polls int
+
+		// change batches sent on the last poll
+		batches []string
+	}{
+		"TestRise": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 2},
+			},
+			targets: []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", "")},
+			polls:   2,
+			batches: []string{"zone-1 syscll.org=10.0.0.1,10.0.0.2"},
+		},
+		"TestRiseNotReached": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 2},
+			},
+			targets: []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", "")},
+			polls:   1,
+		},
+		"TestSafetyGuard": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+				Safety:      safetyConfig{MinHealthy: 2},
+			},
+			targets: []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", "")},
+			failing: map[string]bool{"10.0.0.2": true},
+			polls:   1,
+		},
+		"TestDraining": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+			},
+			targets:  []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", "")},
+			draining: "i-10.0.0.2",
+			removed:  true,
+			polls:    1,
+			batches:  []string{"zone-1 syscll.org=10.0.0.1"},
+		},
+		"TestDrainingSafetyGuard": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+				Safety:      safetyConfig{MinHealthy: 2},
+			},
+			targets:  []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", "")},
+			draining: "i-10.0.0.2",
+			removed:  false,
+			polls:    1,
+		},
+		"TestGeolocation": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+				Routing: routingConfig{
+					Policy: routingGeolocation,
+					Locations: map[string]geoLocationConfig{
+						"eu-west-1": {Continent: "EU"},
+						"us-east-1": {Country: "US"},
+					},
+					DefaultRegion: "us-east-1",
+				},
+			},
+			targets: []target{newTarget("10.0.0.1", "us-east-1"), newTarget("10.0.1.1", "eu-west-1"), newTarget("10.0.1.2", "eu-west-1")},
+			failing: map[string]bool{"10.0.1.2": true},
+			polls:   1,
+			batches: []string{"zone-1 syscll.org/eu-west-1=10.0.1.1 syscll.org/us-east-1=10.0.0.1 syscll.org/default=10.0.0.1"},
+		},
+		"TestWeighted": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+				Routing: routingConfig{
+					Policy: routingWeighted,
+					Canary: &canaryConfig{Weight: 20},
+				},
+			},
+			targets:  []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", ""), newTarget("10.0.0.3", "")},
+			canaries: []target{newTarget("10.0.0.3", "")},
+			polls:    1,
+			batches:  []string{"zone-1 syscll.org/primary@80=10.0.0.1,10.0.0.2 syscll.org/canary@20=10.0.0.3"},
+		},
+		"TestWeightedCanaryError": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+				Routing: routingConfig{
+					Policy: routingWeighted,
+					Canary: &canaryConfig{Weight: 20},
+				},
+			},
+			targets:   []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", ""), newTarget("10.0.0.3", "")},
+			canaryErr: fmt.Errorf("aws error"),
+			polls:     1,
+		},
+		"TestWeightedCanarySafetyGuard": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+				Routing: routingConfig{
+					Policy: routingWeighted,
+					Canary: &canaryConfig{Weight: 50},
+				},
+			},
+			targets:  []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", ""), newTarget("10.0.0.3", "")},
+			canaries: []target{newTarget("10.0.0.3", "")},
+			failing:  map[string]bool{"10.0.0.3": true},
+			current: map[string]*route53.ResourceRecordSet{
+				poolPrimary: newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{SetIdentifier: poolPrimary, Weight: aws.Int64(90)}, []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}, defaultRecordTTL).ResourceRecordSet,
+				poolCanary:  newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{SetIdentifier: poolCanary, Weight: aws.Int64(10)}, []net.IP{net.ParseIP("10.0.0.3")}, defaultRecordTTL).ResourceRecordSet,
+			},
+			polls: 1,
+		},
+		"TestWeightedCanarySafetyGuardPrimaryChanged": {
+			record: recordConfig{
+				HealthCheck: healthCheckConfig{Rise: 1},
+				Routing: routingConfig{
+					Policy: routingWeighted,
+					Canary: &canaryConfig{Weight: 50},
+				},
+			},
+			targets:  []target{newTarget("10.0.0.1", ""), newTarget("10.0.0.2", ""), newTarget("10.0.0.3", "")},
+			canaries: []target{newTarget("10.0.0.3", "")},
+			failing:  map[string]bool{"10.0.0.2": true, "10.0.0.3": true},
+			current: map[string]*route53.ResourceRecordSet{
+				poolPrimary: newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{SetIdentifier: poolPrimary, Weight: aws.Int64(90)}, []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}, defaultRecordTTL).ResourceRecordSet,
+				poolCanary:  newRoute53Change("syscll.org", route53.RRTypeA, recordRouting{SetIdentifier: poolCanary, Weight: aws.Int64(10)}, []net.IP{net.ParseIP("10.0.0.3")}, defaultRecordTTL).ResourceRecordSet,
+			},
+			polls:   1,
+			batches: []string{"zone-1 syscll.org/primary@90=10.0.0.1"},
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			record := test.record
+			record.Name = "syscll.org"
+			record.Source = "static"
+			record.DNS.ZoneID = "zone-1"
+			record.HealthCheck.Schemes = []string{"http"}
+
+			cfg := config{
+				Sources: []sourceConfig{{Name: "static"}},
+				Records: []recordConfig{record},
+			}
+			cfg.setDefaults()
+
+			// any healthy ip addrs of an empty record set are a change
+			var recorded recordedChanges
+			mgr := awsManager{
+				route53: mockRoute53ReadWriter{
+					recordFunc: func(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+						if rrs, ok := test.current[aws.StringValue(input.StartRecordIdentifier)]; ok {
+							return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []*route53.ResourceRecordSet{rrs}}, nil
+						}
+						return &route53.ListResourceRecordSetsOutput{}, nil
+					},
+					changeFunc: recorded.changeFunc(nil),
+				},
+			}
+
+			d := &discovery{
+				route53:     map[string]awsManager{record.Name: mgr},
+				credentials: newCredentialsHealth(nil),
+				sources:     map[string]Discoverer{"static": staticDiscoverer(test.targets)},
+				records:     make(map[string]Discoverer),
+				canaries:    make(map[string]Discoverer),
+				draining:    newDrainSet(),
+				instances:   make(map[string]map[string]bool),
+			}
+			if test.canaries != nil {
+				d.canaries[record.Name] = staticDiscoverer(test.canaries)
+			}
+			if test.canaryErr != nil {
+				d.canaries[record.Name] = discovererFunc(func(context.Context) ([]target, error) {
+					return nil, test.canaryErr
+				})
+			}
+			if test.draining != "" {
+				d.draining.records = func(string) []string { return []string{record.Name} }
+				d.draining.add(test.draining)
+			}
+
+			// number of health check requests of each ip addr
+			var mu sync.Mutex
+			requests := make(map[string]int)
+
+			client := mockDoer{
+				doFunc: func(req *http.Request) (*http.Response, error) {
+					mu.Lock()
+					requests[req.URL.Hostname()]++
+					mu.Unlock()
+
+					if test.failing[req.URL.Hostname()] {
+						return nil, fmt.Errorf("connection refused")
+					}
+					return &http.Response{Body: http.NoBody, StatusCode: http.StatusOK}, nil
+				},
+			}
+
+			tracker := newHealthTracker()
+			for i := 0; i < test.polls; i++ {
+				recorded.batches = nil
+				poll(context.Background(), context.Background(), cfg, d, client, tracker, false)
+			}
+
+			if !reflect.DeepEqual(recorded.batches, test.batches) {
+				t.Errorf("expected batches: %q, got: %q", test.batches, recorded.batches)
+			}
+
+			// ip addrs in several record sets are only checked once per poll
+			for ip, n := range requests {
+				if expected := test.polls * cfg.Records[0].HealthCheck.Attempts; n != expected {
+					t.Errorf("%s: expected %d health check requests, got: %d", ip, expected, n)
+				}
+			}
+
+			if test.draining != "" {
+				select {
+				case <-d.draining.removed(test.draining):
+					if !test.removed {
+						t.Errorf("expected draining instance to still be published")
+					}
+				default:
+					if test.removed {
+						t.Errorf("expected draining instance to be removed from every record")
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	t.Parallel()
+
+	// checks of the fast record take longer than the poll timeout in total,
+	// but not each, while checks of the slow record never finish
+	cfg := config{
+		PollTimeout: duration{time.Second},
+		Sources:     []sourceConfig{{Name: "fast"}, {Name: "slow"}},
+		Records: []recordConfig{
+			{Name: "fast.syscll.org", Source: "fast", DNS: dnsConfig{ZoneID: "zone-1"}, HealthCheck: healthCheckConfig{Rise: 1, Schemes: []string{"http"}}},
+			{Name: "slow.syscll.org", Source: "slow", DNS: dnsConfig{ZoneID: "zone-1"}, HealthCheck: healthCheckConfig{Rise: 1, Schemes: []string{"http"}}},
+		},
+	}
+	cfg.setDefaults()
+
+	var recorded recordedChanges
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+				return &route53.ListResourceRecordSetsOutput{}, nil
+			},
+			changeFunc: recorded.changeFunc(nil),
+		},
+	}
+
+	var fast []target
+	for i := 1; i <= 4; i++ {
+		fast = append(fast, target{IP: net.IPv4(10, 0, 0, byte(i))})
+	}
+
+	d := &discovery{
+		route53: map[string]awsManager{"fast.syscll.org": mgr, "slow.syscll.org": mgr},
+		sources: map[string]Discoverer{
+			"fast": staticDiscoverer(fast),
+			"slow": staticDiscoverer{{IP: net.ParseIP("10.0.1.1")}},
+		},
+		credentials: newCredentialsHealth(nil),
+		records:     make(map[string]Discoverer),
+		canaries:    make(map[string]Discoverer),
+		draining:    newDrainSet(),
+		instances:   make(map[string]map[string]bool),
+	}
+
+	client := mockDoer{
+		doFunc: func(req *http.Request) (*http.Response, error) {
+			delay := 300 * time.Millisecond
+			if req.URL.Hostname() == "10.0.1.1" {
+				delay = time.Hour
+			}
+
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(delay):
+				return &http.Response{Body: http.NoBody, StatusCode: http.StatusOK}, nil
+			}
+		},
+	}
+
+	plans := poll(context.Background(), context.Background(), cfg, d, client, newHealthTracker(), false)
+
+	// only the record set cut short by the poll timeout is left as is
+	expected := []string{"zone-1 fast.syscll.org=10.0.0.1,10.0.0.2,10.0.0.3,10.0.0.4"}
+	if !reflect.DeepEqual(recorded.batches, expected) {
+		t.Errorf("expected batches: %q, got: %q", expected, recorded.batches)
+	}
+	if plans[0].Error != "" || plans[1].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("unexpected plan errors: %q, %q", plans[0].Error, plans[1].Error)
+	}
+}
+
+func TestPollRoutingConflict(t *testing.T) {
+	t.Parallel()
+
+	// the latency record was published with simple routing before, which
+	// route53 would reject the whole batch of the zone for
+	cfg := config{
+		Sources: []sourceConfig{{Name: "static"}},
+		Records: []recordConfig{
+			{Name: "syscll.org", Source: "static", DNS: dnsConfig{ZoneID: "zone-1"}, HealthCheck: healthCheckConfig{Rise: 1, Schemes: []string{"http"}}},
+			{Name: "global.syscll.org", Source: "static", DNS: dnsConfig{ZoneID: "zone-1"}, HealthCheck: healthCheckConfig{Rise: 1, Schemes: []string{"http"}}, Routing: routingConfig{Policy: routingLatency}},
+		},
+	}
+	cfg.setDefaults()
+
+	var recorded recordedChanges
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			recordFunc: func(input *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+				if aws.StringValue(input.StartRecordName) != "global.syscll.org" {
+					return &route53.ListResourceRecordSetsOutput{}, nil
+				}
+				return &route53.ListResourceRecordSetsOutput{
+					ResourceRecordSets: []*route53.ResourceRecordSet{
+						{
+							Name:            aws.String("global.syscll.org."),
+							Type:            aws.String(route53.RRTypeA),
+							TTL:             aws.Int64(60),
+							ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}},
+						},
+					},
+				}, nil
+			},
+			changeFunc: recorded.changeFunc(nil),
+		},
+	}
+
+	d := &discovery{
+		route53:     map[string]awsManager{"syscll.org": mgr, "global.syscll.org": mgr},
+		credentials: newCredentialsHealth(nil),
+		sources:     map[string]Discoverer{"static": staticDiscoverer{{IP: net.ParseIP("10.0.0.1"), Region: "us-east-1"}}},
+		records:     make(map[string]Discoverer),
+		canaries:    make(map[string]Discoverer),
+		draining:    newDrainSet(),
+		instances:   make(map[string]map[string]bool),
+	}
+
+	client := mockDoer{
+		doFunc: func(*http.Request) (*http.Response, error) {
+			return &http.Response{Body: http.NoBody, StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	plans := poll(context.Background(), context.Background(), cfg, d, client, newHealthTracker(), false)
+
+	// the other record of the zone is still updated
+	expected := []string{"zone-1 syscll.org=10.0.0.1"}
+	if !reflect.DeepEqual(recorded.batches, expected) {
+		t.Errorf("expected batches: %q, got: %q", expected, recorded.batches)
+	}
+
+	conflict := "existing record set with simple routing conflicts, delete it to use latency us-east-1 routing"
+	if plans[0].Error != "" || plans[1].Error != conflict {
+		t.Errorf("unexpected plan errors: %q, %q", plans[0].Error, plans[1].Error)
+	}
+}
+
+func TestPollHealthCheckConcurrency(t *testing.T) {
+	t.Parallel()
+
+	cfg := config{
+		HealthCheckConcurrency: 2,
+		Sources:                []sourceConfig{{Name: "static"}},
+		Records: []recordConfig{
+			{Name: "syscll.org", Source: "static", DNS: dnsConfig{ZoneID: "zone-1"}, HealthCheck: healthCheckConfig{Rise: 1, Schemes: []string{"http"}}},
+		},
+	}
+	cfg.setDefaults()
+
+	var targets []target
+	for i := 1; i <= 8; i++ {
+		targets = append(targets, target{IP: net.IPv4(10, 0, 0, byte(i))})
+	}
+
+	var recorded recordedChanges
+	mgr := awsManager{
+		route53: mockRoute53ReadWriter{
+			recordFunc: func(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+				return &route53.ListResourceRecordSetsOutput{}, nil
+			},
+			changeFunc: recorded.changeFunc(nil),
+		},
+	}
+
+	d := &discovery{
+		route53:     map[string]awsManager{"syscll.org": mgr},
+		credentials: newCredentialsHealth(nil),
+		sources:     map[string]Discoverer{"static": staticDiscoverer(targets)},
+		records:     make(map[string]Discoverer),
+		canaries:    make(map[string]Discoverer),
+		draining:    newDrainSet(),
+		instances:   make(map[string]map[string]bool),
+	}
+
+	// ip addrs with health checks in flight, and the most at any time
+	var mu sync.Mutex
+	active := make(map[string]int)
+	var most int
+
+	client := mockDoer{
+		doFunc: func(req *http.Request) (*http.Response, error) {
+			ip := req.URL.Hostname()
+
+			mu.Lock()
+			active[ip]++
+			if len(active) > most {
+				most = len(active)
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			if active[ip]--; active[ip] == 0 {
+				delete(active, ip)
+			}
+			mu.Unlock()
+
+			return &http.Response{Body: http.NoBody, StatusCode: http.StatusOK}, nil
+		},
+	}
+
+	poll(context.Background(), context.Background(), cfg, d, client, newHealthTracker(), false)
+
+	if most > cfg.HealthCheckConcurrency {
+		t.Errorf("expected at most %d ip addrs checked at once, got: %d", cfg.HealthCheckConcurrency, most)
+	}
+	if len(recorded.batches) != 1 {
+		t.Errorf("expected every ip addr to be checked and published, got batches: %q", recorded.batches)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const (
+	// human readable plan output
+	planOutputText = "text"
+
+	// machine readable plan output
+	planOutputJSON = "json"
+)
+
+// recordPlan describes the changes required to bring a single route53 record
+// set in line with its healthy ip addrs
+type recordPlan struct {
+	// host name of the record
+	Record string `json:"record"`
+
+	// type of the record set, A or AAAA
+	Type string `json:"type,omitempty"`
+
+	// set identifier of the record set, empty for simple routing
+	SetIdentifier string `json:"set_identifier,omitempty"`
+
+	// route53 hosted zone id of the record
+	ZoneID string `json:"zone_id,omitempty"`
+
+	// ip addrs currently published in the record set
+	Current []string `json:"current"`
+
+	// healthy ip addrs that should be published
+	Desired []string `json:"desired"`
+
+	// ip addrs that will be added to the record set
+	Added []string `json:"added"`
+
+	// ip addrs that will be removed from the record set
+	Removed []string `json:"removed"`
+
+	// current ttl of the record set, nil if it does not exist
+	CurrentTTL *int64 `json:"current_ttl,omitempty"`
+
+	// ttl the record set should have
+	DesiredTTL int64 `json:"desired_ttl"`
+
+	// current routing of the record set, empty if it does not exist
+	CurrentRouting string `json:"current_routing,omitempty"`
+
+	// routing the record set should have
+	DesiredRouting string `json:"desired_routing"`
+
+	// whether the record set differs from its desired state
+	Changed bool `json:"changed"`
+
+	// reason the record set will not be updated, if any
+	Error string `json:"error,omitempty"`
+
+	// weight of the current record set, nil if it does not exist or is not weighted
+	currentWeight *int64
+}
+
+// newRecordPlan returns the plan of a record set without any ip addrs, which
+// is also the plan reported for record sets that will not be updated
+func newRecordPlan(host, rrType string, routing recordRouting, ttl int64) recordPlan {
+	return recordPlan{
+		Record:         host,
+		Type:           rrType,
+		SetIdentifier:  routing.SetIdentifier,
+		Current:        []string{},
+		Desired:        []string{},
+		Added:          []string{},
+		Removed:        []string{},
+		DesiredTTL:     ttl,
+		DesiredRouting: routing.describe(),
+	}
+}
+
+// diffRecordSet compares a current record set, which may be nil if it does not
+// exist, against the desired routing, ip addrs and ttl
+func diffRecordSet(host string, current *route53.ResourceRecordSet, routing recordRouting, ips []net.IP, ttl int64) recordPlan {
+	plan := newRecordPlan(host, "", routing, ttl)
+
+	desired := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		if s := ip.String(); !desired[s] {
+			desired[s] = true
+			plan.Desired = append(plan.Desired, s)
+		}
+	}
+
+	existing := make(map[string]bool)
+	if current != nil {
+		plan.CurrentTTL = aws.Int64(aws.Int64Value(current.TTL))
+		plan.CurrentRouting = routingOf(current).describe()
+		plan.currentWeight = current.Weight
+
+		for _, rr := range current.ResourceRecords {
+			// route53 returns values as written, so an AAAA value may be
+			// uncompressed or have leading zeros
+			v := aws.StringValue(rr.Value)
+			if ip := net.ParseIP(v); ip != nil {
+				v = ip.String()
+			}
+			if existing[v] {
+				continue
+			}
+			existing[v] = true
+			plan.Current = append(plan.Current, v)
+
+			if !desired[v] {
+				plan.Removed = append(plan.Removed, v)
+			}
+		}
+	}
+
+	for _, ip := range plan.Desired {
+		if !existing[ip] {
+			plan.Added = append(plan.Added, ip)
+		}
+	}
+
+	for _, l := range [][]string{plan.Current, plan.Desired, plan.Added, plan.Removed} {
+		sort.Strings(l)
+	}
+
+	plan.Changed = current == nil || len(plan.Added) > 0 || len(plan.Removed) > 0 || *plan.CurrentTTL != ttl || !routing.matches(current)
+
+	return plan
+}
+
+// writePlans writes a set of record plans in the given output format
+func writePlans(w io.Writer, plans []recordPlan, output string) error {
+	if output == planOutputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plans)
+	}
+
+	for _, plan := range plans {
+		fmt.Fprintf(w, "%s", plan.Record)
+		if plan.Type != "" {
+			fmt.Fprintf(w, " %s", plan.Type)
+		}
+		if plan.SetIdentifier != "" {
+			fmt.Fprintf(w, " [%s]", plan.SetIdentifier)
+		}
+		if plan.ZoneID != "" {
+			fmt.Fprintf(w, " (zone: %s)", plan.ZoneID)
+		}
+		fmt.Fprintln(w, ":")
+
+		switch {
+		case plan.Error != "":
+			fmt.Fprintf(w, "  ! will not update: %s\n", plan.Error)
+		case !plan.Changed:
+			fmt.Fprintln(w, "  no changes")
+		case plan.CurrentTTL == nil:
+			fmt.Fprintf(w, "  create record set with ttl %d", plan.DesiredTTL)
+			if plan.DesiredRouting != routingSimple {
+				fmt.Fprintf(w, ", routing: %s", plan.DesiredRouting)
+			}
+			fmt.Fprintln(w)
+		default:
+			if *plan.CurrentTTL != plan.DesiredTTL {
+				fmt.Fprintf(w, "  ~ ttl %d -> %d\n", *plan.CurrentTTL, plan.DesiredTTL)
+			}
+			if plan.CurrentRouting != plan.DesiredRouting {
+				fmt.Fprintf(w, "  ~ routing %s -> %s\n", plan.CurrentRouting, plan.DesiredRouting)
+			}
+		}
+
+		if plan.Error == "" {
+			for _, ip := range plan.Added {
+				fmt.Fprintf(w, "  + %s\n", ip)
+			}
+			for _, ip := range plan.Removed {
+				fmt.Fprintf(w, "  - %s\n", ip)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestDiffRecordSet(t *testing.T) {
+	t.Parallel()
+
+	recordSet := func(ttl int64, ips ...string) *route53.ResourceRecordSet {
+		rrs := &route53.ResourceRecordSet{
+			Name: aws.String("syscll.org."),
+			TTL:  aws.Int64(ttl),
+			Type: aws.String(route53.RRTypeA),
+		}
+		for _, ip := range ips {
+			rrs.ResourceRecords = append(rrs.ResourceRecords, &route53.ResourceRecord{Value: aws.String(ip)})
+		}
+		return rrs
+	}
+
+	testTable := map[string]struct {
+		current *route53.ResourceRecordSet
+		ips     []string
+		ttl     int64
+		added   []string
+		removed []string
+		changed bool
+	}{
+		"TestCreate": {
+			ips:     []string{"192.168.0.2", "192.168.0.1"},
+			ttl:     60,
+			added:   []string{"192.168.0.1", "192.168.0.2"},
+			removed: []string{},
+			changed: true,
+		},
+		"TestNoChanges": {
+			current: recordSet(60, "192.168.0.1", "192.168.0.2"),
+			ips:     []string{"192.168.0.2", "192.168.0.1"},
+			ttl:     60,
+			added:   []string{},
+			removed: []string{},
+			changed: false,
+		},
+		"TestAddedAndRemoved": {
+			current: recordSet(60, "192.168.0.1", "192.168.0.2"),
+			ips:     []string{"192.168.0.2", "192.168.0.3"},
+			ttl:     60,
+			added:   []string{"192.168.0.3"},
+			removed: []string{"192.168.0.1"},
+			changed: true,
+		},
+		"TestNormalizedIPv6": {
+			current: recordSet(60, "2001:0db8:0000:0000:0000:0000:0000:0001", "2001:DB8::2"),
+			ips:     []string{"2001:db8::1", "2001:db8::2"},
+			ttl:     60,
+			added:   []string{},
+			removed: []string{},
+			changed: false,
+		},
+		"TestTTLChanged": {
+			current: recordSet(300, "192.168.0.1"),
+			ips:     []string{"192.168.0.1"},
+			ttl:     60,
+			added:   []string{},
+			removed: []string{},
+			changed: true,
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var ips []net.IP
+			for _, ip := range test.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+
+			plan := diffRecordSet("syscll.org", test.current, recordRouting{}, ips, test.ttl)
+			if !reflect.DeepEqual(plan.Added, test.added) {
+				t.Errorf("expected added: %v, got: %v", test.added, plan.Added)
+			}
+			if !reflect.DeepEqual(plan.Removed, test.removed) {
+				t.Errorf("expected removed: %v, got: %v", test.removed, plan.Removed)
+			}
+			if plan.Changed != test.changed {
+				t.Errorf("expected changed: %t, got: %t", test.changed, plan.Changed)
+			}
+		})
+	}
+}
+
+func TestWritePlans(t *testing.T) {
+	t.Parallel()
+
+	plans := []recordPlan{
+		{
+			Record:         "syscll.org",
+			Type:           route53.RRTypeA,
+			ZoneID:         "zone-1",
+			Added:          []string{"192.168.0.3"},
+			Removed:        []string{"192.168.0.1"},
+			CurrentTTL:     aws.Int64(300),
+			DesiredTTL:     60,
+			CurrentRouting: routingSimple,
+			DesiredRouting: routingSimple,
+			Changed:        true,
+		},
+		{
+			Record:         "syscll.org",
+			Type:           route53.RRTypeAaaa,
+			ZoneID:         "zone-1",
+			Added:          []string{"2001:db8::1"},
+			DesiredTTL:     60,
+			DesiredRouting: routingSimple,
+			Changed:        true,
+		},
+		{
+			Record:     "ingress.syscll.org",
+			ZoneID:     "zone-1",
+			CurrentTTL: aws.Int64(60),
+			DesiredTTL: 60,
+		},
+		{
+			Record:        "global.syscll.org",
+			Type:          route53.RRTypeA,
+			SetIdentifier: "eu-west-1",
+			ZoneID:        "zone-1",
+			CurrentTTL:    aws.Int64(60),
+			DesiredTTL:    60,
+		},
+		{
+			Record:         "canary.syscll.org",
+			Type:           route53.RRTypeA,
+			SetIdentifier:  poolCanary,
+			ZoneID:         "zone-1",
+			Current:        []string{"192.168.0.1"},
+			Desired:        []string{"192.168.0.1"},
+			Added:          []string{},
+			Removed:        []string{},
+			CurrentTTL:     aws.Int64(60),
+			DesiredTTL:     60,
+			CurrentRouting: "weight 10",
+			DesiredRouting: "weight 50",
+			Changed:        true,
+		},
+		{
+			Record:         "eu.syscll.org",
+			Type:           route53.RRTypeA,
+			SetIdentifier:  "eu-west-1",
+			ZoneID:         "zone-1",
+			Added:          []string{"192.168.0.1"},
+			DesiredTTL:     60,
+			DesiredRouting: "geolocation EU",
+			Changed:        true,
+		},
+		newRecordPlan("haproxy.syscll.org", route53.RRTypeA, recordRouting{}, 60),
+	}
+	plans[len(plans)-1].Error = "no ip addrs found"
+
+	var text bytes.Buffer
+	if err := writePlans(&text, plans, planOutputText); err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	expected := `syscll.org A (zone: zone-1):
+  ~ ttl 300 -> 60
+  + 192.168.0.3
+  - 192.168.0.1
+syscll.org AAAA (zone: zone-1):
+  create record set with ttl 60
+  + 2001:db8::1
+ingress.syscll.org (zone: zone-1):
+  no changes
+global.syscll.org A [eu-west-1] (zone: zone-1):
+  no changes
+canary.syscll.org A [canary] (zone: zone-1):
+  ~ routing weight 10 -> weight 50
+eu.syscll.org A [eu-west-1] (zone: zone-1):
+  create record set with ttl 60, routing: geolocation EU
+  + 192.168.0.1
+haproxy.syscll.org A:
+  ! will not update: no ip addrs found
+`
+	if text.String() != expected {
+		t.Errorf("expected text output:\n%s\ngot:\n%s", expected, text.String())
+	}
+
+	var out bytes.Buffer
+	if err := writePlans(&out, plans, planOutputJSON); err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+
+	var decoded []recordPlan
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected valid json output, got: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, plans) {
+		t.Errorf("expected json output to round trip: %+v, got: %+v", plans, decoded)
+	}
+
+	// plans of record sets that will not be updated keep the shape of every other plan
+	var raw []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &raw); err != nil {
+		t.Fatalf("expected valid json output, got: %v", err)
+	}
+	errPlan := raw[len(raw)-1]
+	for _, field := range []string{"current", "desired", "added", "removed"} {
+		if v, ok := errPlan[field].([]interface{}); !ok || len(v) != 0 {
+			t.Errorf("expected %s of an error plan: [], got: %v", field, errPlan[field])
+		}
+	}
+	if errPlan["desired_ttl"] != float64(60) {
+		t.Errorf("expected desired_ttl of an error plan: 60, got: %v", errPlan["desired_ttl"])
+	}
+}
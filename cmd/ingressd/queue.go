@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maximum number of messages returned by a single ReceiveMessage call
+	sqsMaxMessages = 10
+
+	// how long a single ReceiveMessage call waits for messages to arrive
+	sqsWaitTimeSeconds = 20
+
+	// how long to wait before receiving messages again after an error
+	sqsRetryInterval = 5 * time.Second
+)
+
+// receiveMessages long polls an sqs queue until stop is closed, calling handle
+// for each received message. Handlers delete the messages they have processed
+func receiveMessages(stop <-chan struct{}, client sqsReceiver, queueURL string, handle func(context.Context, *sqs.Message)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		res, err := client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(sqsMaxMessages),
+			WaitTimeSeconds:     aws.Int64(sqsWaitTimeSeconds),
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("queue", queueURL).Msg("error receiving messages")
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(sqsRetryInterval):
+			}
+			continue
+		}
+
+		for _, msg := range res.Messages {
+			handle(ctx, msg)
+		}
+	}
+}
+
+// deleteMessage removes a processed message from an sqs queue
+func deleteMessage(ctx context.Context, client sqsReceiver, queueURL string, msg *sqs.Message) {
+	_, err := client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("queue", queueURL).Str("message.id", aws.StringValue(msg.MessageId)).Msg("error deleting message")
+	}
+}
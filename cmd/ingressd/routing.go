@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const (
+	// a single record set of every healthy ip addr
+	routingSimple = "simple"
+
+	// a record set per region, answered from the region with the lowest latency
+	routingLatency = "latency"
+
+	// a record set per region, answered by the location of the resolver
+	routingGeolocation = "geolocation"
+
+	// a primary and a canary record set, answered in proportion to their weights
+	routingWeighted = "weighted"
+
+	// set identifier of the geolocation record set answering unmatched locations
+	geoDefaultSetIdentifier = "default"
+
+	// route53 country code of the default geolocation
+	geoDefaultCountryCode = "*"
+)
+
+// routingConfig defines the route53 routing policy of a record. Latency and
+// geolocation policies publish a record set per region of the record's source,
+// each with its region as the set identifier. The weighted policy publishes a
+// primary and a canary record set
+type routingConfig struct {
+	// routing policy: simple, latency, geolocation or weighted, default: simple
+	Policy string `yaml:"policy" json:"policy"`
+
+	// canary pool of a weighted record
+	Canary *canaryConfig `yaml:"canary" json:"canary"`
+
+	// geolocation of each region's record set, by region
+	Locations map[string]geoLocationConfig `yaml:"locations" json:"locations"`
+
+	// region whose ip addrs also answer resolvers matching no other location
+	DefaultRegion string `yaml:"default_region" json:"default_region"`
+}
+
+// geoLocationConfig is a route53 geolocation. Only one of continent or
+// country may be set, a subdivision requires a country
+type geoLocationConfig struct {
+	// two letter continent code, e.g: EU
+	Continent string `yaml:"continent" json:"continent"`
+
+	// two letter country code, e.g: US
+	Country string `yaml:"country" json:"country"`
+
+	// subdivision code within the country, e.g: CA
+	Subdivision string `yaml:"subdivision" json:"subdivision"`
+}
+
+// recordRouting identifies a single record set of a record and holds its
+// routing parameters. The zero value is a simple routing record set
+type recordRouting struct {
+	// set identifier of the record set, empty for simple routing
+	SetIdentifier string
+
+	// region of the targets published in the record set, empty for every region
+	Region string
+
+	// latency routing region of the record set
+	LatencyRegion string
+
+	// geolocation of the record set
+	GeoLocation *geoLocationConfig
+
+	// weight of a weighted record set
+	Weight *int64
+
+	// pool of the targets published in a weighted record set: primary or canary
+	Pool string
+}
+
+func (g geoLocationConfig) validate() error {
+	switch {
+	case g.Continent == "" && g.Country == "":
+		return fmt.Errorf("a continent or country is required")
+	case g.Continent != "" && g.Country != "":
+		return fmt.Errorf("only one of continent or country may be set")
+	case g.Subdivision != "" && g.Country == "":
+		return fmt.Errorf("subdivision: requires a country")
+	}
+
+	return nil
+}
+
+func (r routingConfig) validate() error {
+	if r.Policy != routingGeolocation {
+		if len(r.Locations) > 0 {
+			return fmt.Errorf("locations: can only be used with geolocation routing")
+		}
+		if r.DefaultRegion != "" {
+			return fmt.Errorf("default_region: can only be used with geolocation routing")
+		}
+	}
+
+	if r.Policy != routingWeighted && r.Canary != nil {
+		return fmt.Errorf("canary: can only be used with weighted routing")
+	}
+
+	switch r.Policy {
+	case routingSimple, routingLatency:
+	case routingGeolocation:
+		if len(r.Locations) == 0 {
+			return fmt.Errorf("locations: at least one location is required")
+		}
+		for region, loc := range r.Locations {
+			if err := loc.validate(); err != nil {
+				return fmt.Errorf("locations.%s: %w", region, err)
+			}
+		}
+		if _, ok := r.Locations[r.DefaultRegion]; r.DefaultRegion != "" && !ok {
+			return fmt.Errorf("default_region: no location for region: %s", r.DefaultRegion)
+		}
+	case routingWeighted:
+		if r.Canary == nil {
+			return fmt.Errorf("canary: required")
+		}
+		if err := r.Canary.validate(); err != nil {
+			return fmt.Errorf("canary.%w", err)
+		}
+	default:
+		return fmt.Errorf("policy: must be simple, latency, geolocation or weighted, got: %s", r.Policy)
+	}
+
+	return nil
+}
+
+// recordSets returns the routing of each record set of a record, given the
+// regions of its source and the current time, in a stable order
+func (r routingConfig) recordSets(regions []string, now time.Time) []recordRouting {
+	switch r.Policy {
+	case routingWeighted:
+		weight := r.Canary.weight(now)
+		return []recordRouting{
+			{
+				SetIdentifier: poolPrimary,
+				Weight:        aws.Int64(canaryTotalWeight - weight),
+				Pool:          poolPrimary,
+			},
+			{
+				SetIdentifier: poolCanary,
+				Weight:        aws.Int64(weight),
+				Pool:          poolCanary,
+			},
+		}
+	case routingLatency:
+		sets := make([]recordRouting, 0, len(regions))
+		for _, region := range regions {
+			sets = append(sets, recordRouting{
+				SetIdentifier: region,
+				Region:        region,
+				LatencyRegion: region,
+			})
+		}
+		return sets
+	case routingGeolocation:
+		locations := make([]string, 0, len(r.Locations))
+		for region := range r.Locations {
+			locations = append(locations, region)
+		}
+		sort.Strings(locations)
+
+		sets := make([]recordRouting, 0, len(locations)+1)
+		for _, region := range locations {
+			loc := r.Locations[region]
+			sets = append(sets, recordRouting{
+				SetIdentifier: region,
+				Region:        region,
+				GeoLocation:   &loc,
+			})
+		}
+
+		if r.DefaultRegion != "" {
+			sets = append(sets, recordRouting{
+				SetIdentifier: geoDefaultSetIdentifier,
+				Region:        r.DefaultRegion,
+				GeoLocation:   &geoLocationConfig{Country: geoDefaultCountryCode},
+			})
+		}
+		return sets
+	default:
+		return []recordRouting{{}}
+	}
+}
+
+// apply sets the routing parameters of a route53 record set
+func (r recordRouting) apply(rrs *route53.ResourceRecordSet) {
+	if r.SetIdentifier == "" {
+		return
+	}
+
+	rrs.SetIdentifier = aws.String(r.SetIdentifier)
+
+	if r.LatencyRegion != "" {
+		rrs.Region = aws.String(r.LatencyRegion)
+	}
+
+	if r.Weight != nil {
+		rrs.Weight = aws.Int64(*r.Weight)
+	}
+
+	if g := r.GeoLocation; g != nil {
+		rrs.GeoLocation = &route53.GeoLocation{}
+		if g.Continent != "" {
+			rrs.GeoLocation.ContinentCode = aws.String(g.Continent)
+		}
+		if g.Country != "" {
+			rrs.GeoLocation.CountryCode = aws.String(g.Country)
+		}
+		if g.Subdivision != "" {
+			rrs.GeoLocation.SubdivisionCode = aws.String(g.Subdivision)
+		}
+	}
+}
+
+// routingOf returns the routing of a route53 record set
+func routingOf(rrs *route53.ResourceRecordSet) recordRouting {
+	r := recordRouting{
+		SetIdentifier: aws.StringValue(rrs.SetIdentifier),
+		LatencyRegion: aws.StringValue(rrs.Region),
+		Weight:        rrs.Weight,
+	}
+
+	if g := rrs.GeoLocation; g != nil {
+		r.GeoLocation = &geoLocationConfig{
+			Continent:   aws.StringValue(g.ContinentCode),
+			Country:     aws.StringValue(g.CountryCode),
+			Subdivision: aws.StringValue(g.SubdivisionCode),
+		}
+	}
+
+	return r
+}
+
+// describe returns a human readable summary of the routing parameters of a
+// record set, e.g: weight 10 or geolocation US/CA
+func (r recordRouting) describe() string {
+	var parts []string
+	if r.LatencyRegion != "" {
+		parts = append(parts, "latency "+r.LatencyRegion)
+	}
+	if r.Weight != nil {
+		parts = append(parts, fmt.Sprintf("weight %d", *r.Weight))
+	}
+	if g := r.GeoLocation; g != nil {
+		loc := g.Continent
+		if g.Country != "" {
+			loc = g.Country
+		}
+		if g.Subdivision != "" {
+			loc += "/" + g.Subdivision
+		}
+		parts = append(parts, "geolocation "+loc)
+	}
+
+	if len(parts) == 0 {
+		return routingSimple
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// matches reports whether the routing parameters of a route53 record set match
+func (r recordRouting) matches(rrs *route53.ResourceRecordSet) bool {
+	if aws.StringValue(rrs.SetIdentifier) != r.SetIdentifier || aws.StringValue(rrs.Region) != r.LatencyRegion {
+		return false
+	}
+
+	if (rrs.Weight == nil) != (r.Weight == nil) || aws.Int64Value(rrs.Weight) != aws.Int64Value(r.Weight) {
+		return false
+	}
+
+	var want, got geoLocationConfig
+	if r.GeoLocation != nil {
+		want = *r.GeoLocation
+	}
+	if g := rrs.GeoLocation; g != nil {
+		got = geoLocationConfig{
+			Continent:   aws.StringValue(g.ContinentCode),
+			Country:     aws.StringValue(g.CountryCode),
+			Subdivision: aws.StringValue(g.SubdivisionCode),
+		}
+	}
+
+	return want == got
+}
+
+// filterTargetsByRegion returns the targets discovered in the given region, or
+// every target if no region is given
+func filterTargetsByRegion(targets []target, region string) []target {
+	if region == "" {
+		return targets
+	}
+
+	var filtered []target
+	for _, t := range targets {
+		if t.Region == region {
+			filtered = append(filtered, t)
+		}
+	}
+
+	return filtered
+}
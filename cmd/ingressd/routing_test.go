@@ -0,0 +1,266 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestRoutingRecordSets(t *testing.T) {
+	t.Parallel()
+
+	regions := []string{"eu-west-1", "us-east-1"}
+
+	if sets := (routingConfig{Policy: routingSimple}).recordSets(regions, time.Now()); len(sets) != 1 || sets[0] != (recordRouting{}) {
+		t.Errorf("expected a single simple record set, got: %+v", sets)
+	}
+
+	sets := routingConfig{Policy: routingLatency}.recordSets(regions, time.Now())
+	if len(sets) != 2 || sets[0].SetIdentifier != "eu-west-1" || sets[1].LatencyRegion != "us-east-1" || sets[1].Region != "us-east-1" {
+		t.Errorf("expected a latency record set per region, got: %+v", sets)
+	}
+
+	weighted := routingConfig{Policy: routingWeighted, Canary: &canaryConfig{Weight: 10}}
+	sets = weighted.recordSets(regions, time.Now())
+	if len(sets) != 2 || sets[0].Pool != poolPrimary || *sets[0].Weight != 90 || sets[1].SetIdentifier != poolCanary || *sets[1].Weight != 10 {
+		t.Errorf("expected a primary and canary weighted record set, got: %+v", sets)
+	}
+
+	geo := routingConfig{
+		Policy: routingGeolocation,
+		Locations: map[string]geoLocationConfig{
+			"us-east-1": {Country: "US"},
+			"eu-west-1": {Continent: "EU"},
+		},
+		DefaultRegion: "us-east-1",
+	}
+	sets = geo.recordSets(regions, time.Now())
+	if len(sets) != 3 {
+		t.Fatalf("expected a geolocation record set per location and a default, got: %+v", sets)
+	}
+	if sets[0].SetIdentifier != "eu-west-1" || sets[0].GeoLocation.Continent != "EU" {
+		t.Errorf("unexpected eu-west-1 record set: %+v", sets[0])
+	}
+	if sets[2].SetIdentifier != geoDefaultSetIdentifier || sets[2].Region != "us-east-1" || sets[2].GeoLocation.Country != "*" {
+		t.Errorf("unexpected default record set: %+v", sets[2])
+	}
+}
+
+func TestNewRoute53ChangeRouting(t *testing.T) {
+	t.Parallel()
+
+	ips := []net.IP{net.ParseIP("192.168.0.1")}
+
+	testTable := map[string]struct {
+		routing  recordRouting
+		expected route53.ResourceRecordSet
+	}{
+		"TestSimple": {
+			routing:  recordRouting{},
+			expected: route53.ResourceRecordSet{},
+		},
+		"TestLatency": {
+			routing: recordRouting{SetIdentifier: "eu-west-1", Region: "eu-west-1", LatencyRegion: "eu-west-1"},
+			expected: route53.ResourceRecordSet{
+				SetIdentifier: aws.String("eu-west-1"),
+				Region:        aws.String("eu-west-1"),
+			},
+		},
+		"TestWeighted": {
+			routing: recordRouting{SetIdentifier: poolCanary, Weight: aws.Int64(10), Pool: poolCanary},
+			expected: route53.ResourceRecordSet{
+				SetIdentifier: aws.String(poolCanary),
+				Weight:        aws.Int64(10),
+			},
+		},
+		"TestGeolocation": {
+			routing: recordRouting{SetIdentifier: "us-east-1", Region: "us-east-1", GeoLocation: &geoLocationConfig{Country: "US", Subdivision: "CA"}},
+			expected: route53.ResourceRecordSet{
+				SetIdentifier: aws.String("us-east-1"),
+				GeoLocation: &route53.GeoLocation{
+					CountryCode:     aws.String("US"),
+					SubdivisionCode: aws.String("CA"),
+				},
+			},
+		},
+	}
+
+	for name, test := range testTable {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rrs := newRoute53Change("syscll.org", route53.RRTypeA, test.routing, ips, defaultRecordTTL).ResourceRecordSet
+
+			if aws.StringValue(rrs.SetIdentifier) != aws.StringValue(test.expected.SetIdentifier) {
+				t.Errorf("expected set identifier: %s, got: %s", aws.StringValue(test.expected.SetIdentifier), aws.StringValue(rrs.SetIdentifier))
+			}
+			if aws.Int64Value(rrs.Weight) != aws.Int64Value(test.expected.Weight) {
+				t.Errorf("expected weight: %d, got: %d", aws.Int64Value(test.expected.Weight), aws.Int64Value(rrs.Weight))
+			}
+			if aws.StringValue(rrs.Region) != aws.StringValue(test.expected.Region) {
+				t.Errorf("expected region: %s, got: %s", aws.StringValue(test.expected.Region), aws.StringValue(rrs.Region))
+			}
+			if (rrs.GeoLocation == nil) != (test.expected.GeoLocation == nil) {
+				t.Fatalf("expected geolocation: %v, got: %v", test.expected.GeoLocation, rrs.GeoLocation)
+			}
+			if g := rrs.GeoLocation; g != nil {
+				want := test.expected.GeoLocation
+				if aws.StringValue(g.ContinentCode) != aws.StringValue(want.ContinentCode) || aws.StringValue(g.CountryCode) != aws.StringValue(want.CountryCode) || aws.StringValue(g.SubdivisionCode) != aws.StringValue(want.SubdivisionCode) {
+					t.Errorf("expected geolocation: %v, got: %v", want, g)
+				}
+			}
+			if len(rrs.ResourceRecords) != 1 || aws.StringValue(rrs.ResourceRecords[0].Value) != "192.168.0.1" {
+				t.Errorf("unexpected resource records: %v", rrs.ResourceRecords)
+			}
+
+			// a record set built from the routing always matches it
+			if !test.routing.matches(rrs) {
+				t.Errorf("expected record set to match its routing")
+			}
+		})
+	}
+}
+
+func TestDiffRecordSetRouting(t *testing.T) {
+	t.Parallel()
+
+	ips := []net.IP{net.ParseIP("192.168.0.1")}
+	routing := recordRouting{SetIdentifier: "eu-west-1", Region: "eu-west-1", GeoLocation: &geoLocationConfig{Continent: "EU"}}
+
+	current := newRoute53Change("syscll.org", route53.RRTypeA, routing, ips, defaultRecordTTL).ResourceRecordSet
+	if plan := diffRecordSet("syscll.org", current, routing, ips, defaultRecordTTL); plan.Changed {
+		t.Errorf("expected no changes, got: %+v", plan)
+	}
+
+	// a changed weight updates the record set
+	weighted := recordRouting{SetIdentifier: poolCanary, Weight: aws.Int64(10), Pool: poolCanary}
+	current = newRoute53Change("syscll.org", route53.RRTypeA, weighted, ips, defaultRecordTTL).ResourceRecordSet
+	weighted.Weight = aws.Int64(20)
+	if plan := diffRecordSet("syscll.org", current, weighted, ips, defaultRecordTTL); !plan.Changed {
+		t.Errorf("expected a change of weight to change the record set")
+	}
+	current = newRoute53Change("syscll.org", route53.RRTypeA, routing, ips, defaultRecordTTL).ResourceRecordSet
+
+	// a changed location updates the record set
+	moved := routing
+	moved.GeoLocation = &geoLocationConfig{Country: "GB"}
+	if plan := diffRecordSet("syscll.org", current, moved, ips, defaultRecordTTL); !plan.Changed {
+		t.Errorf("expected a change of location to change the record set")
+	}
+}
+
+func TestRecordRoutingDescribe(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		routing  recordRouting
+		expected string
+	}{
+		"TestSimple": {
+			expected: routingSimple,
+		},
+		"TestLatency": {
+			routing:  recordRouting{SetIdentifier: "eu-west-1", Region: "eu-west-1", LatencyRegion: "eu-west-1"},
+			expected: "latency eu-west-1",
+		},
+		"TestWeighted": {
+			routing:  recordRouting{SetIdentifier: poolCanary, Weight: aws.Int64(0), Pool: poolCanary},
+			expected: "weight 0",
+		},
+		"TestGeolocationSubdivision": {
+			routing:  recordRouting{SetIdentifier: "us-west-1", GeoLocation: &geoLocationConfig{Country: "US", Subdivision: "CA"}},
+			expected: "geolocation US/CA",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := test.routing.describe(); got != test.expected {
+				t.Errorf("expected: %s, got: %s", test.expected, got)
+			}
+
+			// the routing read back from a record set describes the same
+			rrs := &route53.ResourceRecordSet{}
+			test.routing.apply(rrs)
+			if got := routingOf(rrs).describe(); got != test.expected {
+				t.Errorf("expected routing of record set: %s, got: %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestRoutingConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		routing routingConfig
+		err     string
+	}{
+		"TestLatency": {
+			routing: routingConfig{Policy: routingLatency},
+		},
+		"TestInvalidPolicyError": {
+			routing: routingConfig{Policy: "weighted-random"},
+			err:     "policy: must be simple, latency, geolocation or weighted, got: weighted-random",
+		},
+		"TestLatencyLocationsError": {
+			routing: routingConfig{Policy: routingLatency, Locations: map[string]geoLocationConfig{"eu-west-1": {Continent: "EU"}}},
+			err:     "locations: can only be used with geolocation routing",
+		},
+		"TestWeightedMissingCanaryError": {
+			routing: routingConfig{Policy: routingWeighted},
+			err:     "canary: required",
+		},
+		"TestCanaryPolicyError": {
+			routing: routingConfig{Policy: routingLatency, Canary: &canaryConfig{}},
+			err:     "canary: can only be used with weighted routing",
+		},
+		"TestGeolocationMissingLocationsError": {
+			routing: routingConfig{Policy: routingGeolocation},
+			err:     "locations: at least one location is required",
+		},
+		"TestGeolocationContinentAndCountryError": {
+			routing: routingConfig{Policy: routingGeolocation, Locations: map[string]geoLocationConfig{"eu-west-1": {Continent: "EU", Country: "GB"}}},
+			err:     "locations.eu-west-1: only one of continent or country may be set",
+		},
+		"TestGeolocationDefaultRegionError": {
+			routing: routingConfig{Policy: routingGeolocation, Locations: map[string]geoLocationConfig{"eu-west-1": {Continent: "EU"}}, DefaultRegion: "us-east-1"},
+			err:     "default_region: no location for region: us-east-1",
+		},
+	}
+
+	for name, test := range testTable {
+		name, test := name, test
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := test.routing.validate()
+			if test.err == "" && err != nil {
+				t.Errorf("expected error: nil, got: %v", err)
+			}
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+			}
+		})
+	}
+}
+
+func TestFilterTargetsByRegion(t *testing.T) {
+	t.Parallel()
+
+	targets := []target{{InstanceID: "1", Region: "eu-west-1"}, {InstanceID: "2", Region: "us-east-1"}}
+
+	if filtered := filterTargetsByRegion(targets, ""); len(filtered) != 2 {
+		t.Errorf("expected every target without a region, got: %v", filtered)
+	}
+	if filtered := filterTargetsByRegion(targets, "us-east-1"); len(filtered) != 1 || filtered[0].InstanceID != "2" {
+		t.Errorf("expected the us-east-1 target, got: %v", filtered)
+	}
+}
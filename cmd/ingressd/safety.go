@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus gauge for storing whether the safety guard is preventing a record update
+var safetyGuardActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ingressd_safety_guard_active",
+	Help: "Whether a record update is being held back by its minimum healthy safety policy, 1 if active",
+}, []string{"record", "type"})
+
+// required returns the minimum number of healthy ip addrs that must remain
+// published, given the number of discovered ip addrs. A record set is never
+// emptied, so at least one ip addr is always required
+func (s safetyConfig) required(discovered int) int {
+	required := s.MinHealthy
+	if required < 1 {
+		required = 1
+	}
+
+	if s.MinHealthyPercent > 0 {
+		if p := int(math.Ceil(float64(discovered) * s.MinHealthyPercent / 100)); p > required {
+			required = p
+		}
+	}
+
+	return required
+}
+
+// ensureSafeRecordSet checks the healthy ip addrs of a record set against its
+// record's safety policy. If too few ip addrs are healthy, an error is returned
+// and the safety guard metric is raised so the current record set is kept (fail-open)
+func ensureSafeRecordSet(record recordConfig, rrType string, healthy, discovered int) error {
+	if required := record.Safety.required(discovered); healthy < required {
+		safetyGuardActive.WithLabelValues(record.Name, rrType).Set(1)
+
+		return fmt.Errorf("only %d of %d discovered ip addrs are healthy, at least %d required", healthy, discovered, required)
+	}
+
+	safetyGuardActive.WithLabelValues(record.Name, rrType).Set(0)
+
+	return nil
+}
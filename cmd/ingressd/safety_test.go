@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestEnsureSafeRecordSet(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		safety     safetyConfig
+		healthy    int
+		discovered int
+		err        string
+	}{
+		"TestNoPolicy": {
+			healthy:    1,
+			discovered: 10,
+		},
+		"TestNoHealthyError": {
+			healthy:    0,
+			discovered: 10,
+			err:        "only 0 of 10 discovered ip addrs are healthy, at least 1 required",
+		},
+		"TestMinHealthySuccess": {
+			safety:     safetyConfig{MinHealthy: 2},
+			healthy:    2,
+			discovered: 10,
+		},
+		"TestMinHealthyError": {
+			safety:     safetyConfig{MinHealthy: 2},
+			healthy:    1,
+			discovered: 10,
+			err:        "only 1 of 10 discovered ip addrs are healthy, at least 2 required",
+		},
+		"TestMinHealthyPercentSuccess": {
+			safety:     safetyConfig{MinHealthyPercent: 50},
+			healthy:    2,
+			discovered: 3,
+		},
+		"TestMinHealthyPercentRoundsUpError": {
+			safety:     safetyConfig{MinHealthyPercent: 50},
+			healthy:    1,
+			discovered: 3,
+			err:        "only 1 of 3 discovered ip addrs are healthy, at least 2 required",
+		},
+		"TestHighestRequirementWins": {
+			safety:     safetyConfig{MinHealthy: 3, MinHealthyPercent: 10},
+			healthy:    2,
+			discovered: 4,
+			err:        "only 2 of 4 discovered ip addrs are healthy, at least 3 required",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			record := recordConfig{Name: "syscll.org", Safety: test.safety}
+
+			err := ensureSafeRecordSet(record, route53.RRTypeA, test.healthy, test.discovered)
+			if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+			}
+			if test.err == "" && err != nil {
+				t.Errorf("expected error: nil, got: %v", err)
+			}
+		})
+	}
+}
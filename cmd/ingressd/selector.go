@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// instances must match every tag filter
+	tagMatchAll = "all"
+
+	// instances must match at least one tag filter
+	tagMatchAny = "any"
+)
+
+// tagFilter matches ec2 instances by a single tag. A filter without values only
+// requires the tag key to exist
+type tagFilter struct {
+	// tag key to match
+	Key string `yaml:"key" json:"key"`
+
+	// single allowed tag value, shorthand for a values list of one
+	Value string `yaml:"value" json:"value"`
+
+	// allowed tag values, an instance matches if its tag has any of them
+	Values []string `yaml:"values" json:"values"`
+}
+
+// tagSelector selects ec2 instances by their tags
+type tagSelector struct {
+	// how tag filters are combined: all or any, default: all
+	Match string `yaml:"match" json:"match"`
+
+	// tag filters an instance must match
+	Tags []tagFilter `yaml:"tags" json:"tags"`
+
+	// tag filters that exclude an otherwise matching instance
+	Exclude []tagFilter `yaml:"exclude" json:"exclude"`
+}
+
+// values returns every allowed value of a tag filter
+func (f tagFilter) values() []string {
+	if f.Value == "" {
+		return f.Values
+	}
+
+	return append([]string{f.Value}, f.Values...)
+}
+
+// ec2Filter translates a tag filter into a server-side ec2 filter
+func (f tagFilter) ec2Filter() *ec2.Filter {
+	values := f.values()
+	if len(values) == 0 {
+		return &ec2.Filter{
+			Name:   aws.String("tag-key"),
+			Values: aws.StringSlice([]string{f.Key}),
+		}
+	}
+
+	return &ec2.Filter{
+		Name:   aws.String(fmt.Sprintf("tag:%s", f.Key)),
+		Values: aws.StringSlice(values),
+	}
+}
+
+// matches reports whether a set of ec2 instance tags matches the filter
+func (f tagFilter) matches(tags []*ec2.Tag) bool {
+	values := f.values()
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) != f.Key {
+			continue
+		}
+
+		return len(values) == 0 || containsString(values, aws.StringValue(tag.Value))
+	}
+
+	return false
+}
+
+func (f tagFilter) validate() error {
+	if f.Key == "" {
+		return fmt.Errorf("key: required")
+	}
+
+	for _, v := range f.values() {
+		if v == "" {
+			return fmt.Errorf("%s: values: must not be empty", f.Key)
+		}
+	}
+
+	return nil
+}
+
+// queries returns the ec2 filters of each DescribeInstances query required to
+// find matching instances. Filters of a single query are combined by ec2 with
+// AND, so matching any tag requires one query per filter
+func (s tagSelector) queries() [][]*ec2.Filter {
+	if s.Match == tagMatchAny {
+		queries := make([][]*ec2.Filter, 0, len(s.Tags))
+		for _, f := range s.Tags {
+			queries = append(queries, []*ec2.Filter{f.ec2Filter()})
+		}
+		return queries
+	}
+
+	filters := make([]*ec2.Filter, 0, len(s.Tags))
+	for _, f := range s.Tags {
+		filters = append(filters, f.ec2Filter())
+	}
+
+	return [][]*ec2.Filter{filters}
+}
+
+// excludes reports whether a set of ec2 instance tags matches any exclusion.
+// ec2 has no negative filters, so exclusions are applied after querying
+func (s tagSelector) excludes(tags []*ec2.Tag) bool {
+	for _, f := range s.Exclude {
+		if f.matches(tags) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s tagSelector) validate() error {
+	if s.Match != "" && s.Match != tagMatchAll && s.Match != tagMatchAny {
+		return fmt.Errorf("match: must be all or any, got: %s", s.Match)
+	}
+
+	// an empty selector would match every instance in the region
+	if len(s.Tags) == 0 {
+		return fmt.Errorf("tags: at least one tag is required")
+	}
+
+	for i, f := range s.Tags {
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("tags[%d].%w", i, err)
+		}
+	}
+
+	for i, f := range s.Exclude {
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("exclude[%d].%w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// parseTagSelector parses a comma separated list of tag filters, all of which
+// must match, e.g: 'Role:ingress,Env:prod|staging,Public,!Draining'. A filter
+// without a value only requires the key to exist, multiple values are separated
+// by '|' and a leading '!' excludes matching instances
+func parseTagSelector(s string) (tagSelector, error) {
+	var sel tagSelector
+
+	for _, term := range strings.Split(s, ",") {
+		if term = strings.TrimSpace(term); term == "" {
+			continue
+		}
+
+		exclude := strings.HasPrefix(term, "!")
+		term = strings.TrimPrefix(term, "!")
+
+		// parse valid tag into parts, parts[0] == key, parts[1] == values
+		parts := strings.SplitN(term, ":", 2)
+
+		f := tagFilter{Key: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			for _, v := range strings.Split(parts[1], "|") {
+				f.Values = append(f.Values, strings.TrimSpace(v))
+			}
+		}
+
+		if err := f.validate(); err != nil {
+			return sel, fmt.Errorf("invalid tag: %s: %w", term, err)
+		}
+
+		if exclude {
+			sel.Exclude = append(sel.Exclude, f)
+		} else {
+			sel.Tags = append(sel.Tags, f)
+		}
+	}
+
+	if len(sel.Tags) == 0 {
+		return sel, fmt.Errorf("at least one tag is required")
+	}
+
+	return sel, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestParseTagSelector(t *testing.T) {
+	t.Parallel()
+
+	testTable := map[string]struct {
+		input    string
+		expected tagSelector
+		err      string
+	}{
+		"TestSingleTag": {
+			input:    "Name:haproxy",
+			expected: tagSelector{Tags: []tagFilter{{Key: "Name", Values: []string{"haproxy"}}}},
+		},
+		"TestMultipleTags": {
+			input: "Role:ingress, Env:prod|staging",
+			expected: tagSelector{Tags: []tagFilter{
+				{Key: "Role", Values: []string{"ingress"}},
+				{Key: "Env", Values: []string{"prod", "staging"}},
+			}},
+		},
+		"TestKeyExists": {
+			input:    "Role:ingress,Public",
+			expected: tagSelector{Tags: []tagFilter{{Key: "Role", Values: []string{"ingress"}}, {Key: "Public"}}},
+		},
+		"TestExclude": {
+			input: "Role:ingress,!Draining,!Env:dev",
+			expected: tagSelector{
+				Tags:    []tagFilter{{Key: "Role", Values: []string{"ingress"}}},
+				Exclude: []tagFilter{{Key: "Draining"}, {Key: "Env", Values: []string{"dev"}}},
+			},
+		},
+		"TestEmptyError": {
+			input: "",
+			err:   "at least one tag is required",
+		},
+		"TestOnlyExcludeError": {
+			input: "!Draining",
+			err:   "at least one tag is required",
+		},
+		"TestEmptyKeyError": {
+			input: ":haproxy",
+			err:   "invalid tag: :haproxy: key: required",
+		},
+		"TestEmptyValueError": {
+			input: "Env:prod|",
+			err:   "invalid tag: Env:prod|: Env: values: must not be empty",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			sel, err := parseTagSelector(test.input)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected error: nil, got: %v", err)
+			}
+
+			if got, expected := selectorString(sel), selectorString(test.expected); got != expected {
+				t.Errorf("expected selector: %s, got: %s", expected, got)
+			}
+		})
+	}
+}
+
+func TestTagSelectorQueries(t *testing.T) {
+	t.Parallel()
+
+	sel := tagSelector{Tags: []tagFilter{
+		{Key: "Role", Value: "ingress"},
+		{Key: "Env", Values: []string{"prod", "staging"}},
+		{Key: "Public"},
+	}}
+
+	queries := sel.queries()
+	if len(queries) != 1 || len(queries[0]) != 3 {
+		t.Fatalf("expected a single query of 3 filters, got: %v", queries)
+	}
+	if f := queries[0][1]; aws.StringValue(f.Name) != "tag:Env" || len(f.Values) != 2 {
+		t.Errorf("unexpected tag value filter: %v", f)
+	}
+	if f := queries[0][2]; aws.StringValue(f.Name) != "tag-key" || aws.StringValue(f.Values[0]) != "Public" {
+		t.Errorf("unexpected tag key filter: %v", f)
+	}
+
+	sel.Match = tagMatchAny
+	if queries := sel.queries(); len(queries) != 3 || len(queries[0]) != 1 {
+		t.Errorf("expected 3 queries of a single filter, got: %v", queries)
+	}
+}
+
+func TestTagFilterMatches(t *testing.T) {
+	t.Parallel()
+
+	tags := []*ec2.Tag{
+		{Key: aws.String("Role"), Value: aws.String("ingress")},
+		{Key: aws.String("Draining"), Value: aws.String("")},
+	}
+
+	testTable := map[string]struct {
+		filter   tagFilter
+		expected bool
+	}{
+		"TestKeyExists":    {filter: tagFilter{Key: "Draining"}, expected: true},
+		"TestKeyMissing":   {filter: tagFilter{Key: "Env"}, expected: false},
+		"TestValueMatch":   {filter: tagFilter{Key: "Role", Values: []string{"edge", "ingress"}}, expected: true},
+		"TestValueMissing": {filter: tagFilter{Key: "Role", Value: "edge"}, expected: false},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if matched := test.filter.matches(tags); matched != test.expected {
+				t.Errorf("expected match: %t, got: %t", test.expected, matched)
+			}
+		})
+	}
+}
+
+// selectorString formats a selector so it can be compared in tests
+func selectorString(sel tagSelector) string {
+	format := func(filters []tagFilter) string {
+		var parts []string
+		for _, f := range filters {
+			parts = append(parts, f.Key+":"+strings.Join(f.values(), "|"))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	return sel.Match + ";" + format(sel.Tags) + ";" + format(sel.Exclude)
+}
@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// watchFile calls onChange whenever a file may have changed, until stop is
+// closed. The parent directory is watched with inotify, as files are often
+// replaced by renaming rather than written in place. If inotify is unavailable,
+// the file is polled instead
+func watchFile(path string, stop <-chan struct{}, onChange func()) {
+	if err := inotifyFile(path, stop, onChange); err != nil {
+		log.Error().Err(err).Str("path", path).Msgf("error watching file, polling every %s instead", filePollInterval)
+		pollFile(path, filePollInterval, stop, onChange)
+	}
+}
+
+func inotifyFile(path string, stop <-chan struct{}, onChange func()) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("error creating inotify instance: %w", err)
+	}
+
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		syscall.Close(fd)
+		return fmt.Errorf("error watching directory: %w", err)
+	}
+
+	// a non-blocking fd is handled by the runtime poller, so closing the file
+	// interrupts a pending read
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-stop
+		f.Close()
+	}()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+				return fmt.Errorf("error reading inotify events: %w", err)
+			}
+		}
+
+		// any event in the directory may affect the file, e.g: kubernetes
+		// configmaps swap a symlinked directory, so the file is reloaded and
+		// compared rather than matching on event names
+		if n > 0 {
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// watchFile calls onChange whenever a file may have changed, until stop is
+// closed. inotify is only available on linux, so the file is polled instead
+func watchFile(path string, stop <-chan struct{}, onChange func()) {
+	pollFile(path, filePollInterval, stop, onChange)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// only match public hosted zones
+	zoneTypePublic = "public"
+
+	// only match private hosted zones
+	zoneTypePrivate = "private"
+)
+
+// Prometheus counter for storing the number of hosted zone cache hits and misses
+var zoneCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingressd_zone_cache_requests_total",
+	Help: "Total number of hosted zone cache lookups by result: hit or miss",
+}, []string{"result"})
+
+// zoneCache caches the full list of Route53 hosted zones for a given ttl, so
+// that zones are not listed for every record on every poll
+type zoneCache struct {
+	// lists every hosted zone, following pagination
+	list func(context.Context) ([]*route53.HostedZone, error)
+
+	// how long a listed set of zones is considered fresh
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	zones   []*route53.HostedZone
+	expires time.Time
+
+	// associated vpc ids of private zones, keyed by zone id and cleared on refresh
+	vpcs map[string][]string
+
+	// refresh in progress, shared by every concurrent caller
+	refreshing *zoneRefresh
+}
+
+// zoneRefresh is a single listing of hosted zones, done is closed once err is set
+type zoneRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// newZoneCache creates an empty hosted zone cache
+func newZoneCache(list func(context.Context) ([]*route53.HostedZone, error), ttl time.Duration) *zoneCache {
+	return &zoneCache{
+		list: list,
+		ttl:  ttl,
+	}
+}
+
+// get returns the cached hosted zones, listing them if the cache is empty or
+// has expired. If listing fails, any stale zones are returned instead
+func (c *zoneCache) get(ctx context.Context) ([]*route53.HostedZone, error) {
+	c.mu.RLock()
+	zones, expires := c.zones, c.expires
+	c.mu.RUnlock()
+
+	if zones != nil && time.Now().Before(expires) {
+		zoneCacheRequests.WithLabelValues("hit").Inc()
+		return zones, nil
+	}
+
+	zoneCacheRequests.WithLabelValues("miss").Inc()
+
+	if err := c.refresh(ctx); err != nil {
+		if zones != nil {
+			log.Error().Err(err).Msg("error refreshing hosted zones, using stale cache")
+			return zones, nil
+		}
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.zones, nil
+}
+
+// refresh lists all hosted zones and replaces the cached zones. Concurrent
+// callers wait for the refresh already in progress instead of listing again
+func (c *zoneCache) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	if r := c.refreshing; r != nil {
+		c.mu.Unlock()
+
+		select {
+		case <-r.done:
+			return r.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	r := &zoneRefresh{done: make(chan struct{})}
+	c.refreshing = r
+	c.mu.Unlock()
+
+	zones, err := c.list(ctx)
+
+	c.mu.Lock()
+	if err == nil {
+		// a nil set of zones is treated as an empty cache
+		if zones == nil {
+			zones = []*route53.HostedZone{}
+		}
+
+		c.zones = zones
+		c.expires = time.Now().Add(c.ttl)
+		c.vpcs = nil
+	}
+	c.refreshing = nil
+	c.mu.Unlock()
+
+	r.err = err
+	close(r.done)
+
+	return err
+}
+
+// getVPCs returns the cached vpc associations of a private zone, fetching them
+// if they have not been cached since the last refresh
+func (c *zoneCache) getVPCs(ctx context.Context, zoneID string, fetch func(context.Context, string) ([]string, error)) ([]string, error) {
+	c.mu.RLock()
+	vpcs, ok := c.vpcs[zoneID]
+	c.mu.RUnlock()
+
+	if ok {
+		return vpcs, nil
+	}
+
+	vpcs, err := fetch(ctx, zoneID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.vpcs == nil {
+		c.vpcs = make(map[string][]string)
+	}
+	c.vpcs[zoneID] = vpcs
+	c.mu.Unlock()
+
+	return vpcs, nil
+}
+
+// run refreshes the cache in the background before it expires, until the given
+// context is cancelled
+func (c *zoneCache) run(ctx context.Context) {
+	// refresh at half the ttl so lookups rarely miss
+	t := time.NewTicker(c.ttl / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := c.refresh(ctx); err != nil {
+				log.Error().Err(err).Msg("error refreshing hosted zone cache")
+			}
+		}
+	}
+}
+
+// matchRoute53HostedZone finds the most specific hosted zone that a given host
+// belongs to. Names are compared on dns label boundaries, ignoring case and any
+// trailing '.', so 'notsyscll.org' does not belong to 'syscll.org'.
+// Zones can be filtered by type, public or private, and if a vpc id is given only
+// private zones associated with it, as listed in vpcs by zone id, are matched.
+// If several zones of the same name match, the zone type or vpc id is ambiguous
+// and an error is returned
+func matchRoute53HostedZone(host string, zones []*route53.HostedZone, zoneType, vpcID string, vpcs map[string][]string) (*route53.HostedZone, error) {
+	var found []*route53.HostedZone
+	var foundName string
+
+	for _, zone := range zones {
+		name := normalizeHost(aws.StringValue(zone.Name))
+		if !hostInZone(host, name) {
+			continue
+		}
+
+		private := isPrivateZone(zone)
+		if (zoneType == zoneTypePublic && private) || (zoneType == zoneTypePrivate && !private) {
+			continue
+		}
+
+		if vpcID != "" && (!private || !containsString(vpcs[aws.StringValue(zone.Id)], vpcID)) {
+			continue
+		}
+
+		// prefer the most specific match, e.g: a host of 'a.ingressd.syscll.org'
+		// belongs to both 'ingressd.syscll.org' and 'syscll.org', but should use
+		// 'ingressd.syscll.org'
+		switch {
+		case len(name) > len(foundName):
+			found, foundName = []*route53.HostedZone{zone}, name
+		case name == foundName:
+			found = append(found, zone)
+		}
+	}
+
+	switch {
+	case len(found) == 0 || aws.StringValue(found[0].Id) == "":
+		return nil, fmt.Errorf("no zone id found for: %s", host)
+	case len(found) > 1:
+		return nil, fmt.Errorf("%d hosted zones named %s match: %s, set a zone type, vpc id or zone id", len(found), foundName, host)
+	}
+
+	return found[0], nil
+}
+
+// hostInZone reports whether a host belongs to a zone on dns label boundaries
+func hostInZone(host, zone string) bool {
+	host, zone = normalizeHost(host), normalizeHost(zone)
+
+	return host == zone || strings.HasSuffix(host, "."+zone)
+}
+
+// isPrivateZone reports whether a hosted zone is private
+func isPrivateZone(zone *route53.HostedZone) bool {
+	return zone.Config != nil && aws.BoolValue(zone.Config.PrivateZone)
+}
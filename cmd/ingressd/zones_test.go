@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func TestZoneCache(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	var listErr error
+	cache := newZoneCache(func(context.Context) ([]*route53.HostedZone, error) {
+		calls++
+		if listErr != nil {
+			return nil, listErr
+		}
+		return []*route53.HostedZone{
+			{
+				Id:   aws.String(fmt.Sprintf("zone-%d", calls)),
+				Name: aws.String("syscll.org."),
+			},
+		}, nil
+	}, time.Hour)
+
+	// first lookup misses and lists zones
+	zones, err := cache.get(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if calls != 1 || aws.StringValue(zones[0].Id) != "zone-1" {
+		t.Fatalf("expected zones to be listed once, got: %d calls", calls)
+	}
+
+	// second lookup hits the cache
+	if _, err := cache.get(context.Background()); err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected cache hit, got: %d calls", calls)
+	}
+
+	// an expired cache is refreshed on lookup
+	cache.expires = time.Now().Add(-time.Second)
+	zones, err = cache.get(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if calls != 2 || aws.StringValue(zones[0].Id) != "zone-2" {
+		t.Errorf("expected zones to be refreshed, got: %d calls", calls)
+	}
+
+	// an expired cache that fails to refresh returns the stale zones
+	cache.expires = time.Now().Add(-time.Second)
+	listErr = fmt.Errorf("route53 error")
+	zones, err = cache.get(context.Background())
+	if err != nil {
+		t.Fatalf("expected error: nil, got: %v", err)
+	}
+	if aws.StringValue(zones[0].Id) != "zone-2" {
+		t.Errorf("expected stale zones, got: %s", aws.StringValue(zones[0].Id))
+	}
+}
+
+func TestZoneCacheError(t *testing.T) {
+	t.Parallel()
+
+	cache := newZoneCache(func(context.Context) ([]*route53.HostedZone, error) {
+		return nil, fmt.Errorf("route53 error")
+	}, time.Hour)
+
+	if _, err := cache.get(context.Background()); err == nil || err.Error() != "route53 error" {
+		t.Errorf("expected error: 'route53 error', got: '%v'", err)
+	}
+}
+
+func TestZoneCacheConcurrentRefresh(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := newZoneCache(func(context.Context) ([]*route53.HostedZone, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return []*route53.HostedZone{{Id: aws.String("zone-1"), Name: aws.String("syscll.org.")}}, nil
+	}, time.Hour)
+
+	// every record set of a poll looks up zones of a cold cache at once
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = cache.get(context.Background())
+		}(i)
+	}
+
+	<-started
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("expected error: nil, got: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected zones to be listed once, got: %d calls", calls)
+	}
+}
+
+func TestMatchRoute53HostedZone(t *testing.T) {
+	t.Parallel()
+
+	zone := func(id, name string, private bool) *route53.HostedZone {
+		return &route53.HostedZone{
+			Id:     aws.String(id),
+			Name:   aws.String(name),
+			Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(private)},
+		}
+	}
+
+	zones := []*route53.HostedZone{
+		zone("public", "syscll.org.", false),
+		zone("private", "syscll.org.", true),
+		zone("sub", "ingressd.syscll.org.", false),
+		zone("other", "example.org.", false),
+		zone("vpc-zone", "internal.example.org.", true),
+	}
+
+	vpcs := map[string][]string{
+		"private":  {"vpc-1"},
+		"vpc-zone": {"vpc-1", "vpc-2"},
+	}
+
+	testTable := map[string]struct {
+		host     string
+		zoneType string
+		vpcID    string
+		expected string
+		err      string
+	}{
+		"TestExactMatch": {
+			host:     "ingressd.syscll.org",
+			expected: "sub",
+		},
+		"TestMostSpecificMatch": {
+			host:     "a.ingressd.syscll.org",
+			expected: "sub",
+		},
+		"TestLabelBoundary": {
+			host: "notexample.org",
+			err:  "no zone id found for: notexample.org",
+		},
+		"TestLabelBoundaryParent": {
+			host:     "notingressd.syscll.org",
+			zoneType: zoneTypePublic,
+			expected: "public",
+		},
+		"TestCaseInsensitive": {
+			host:     "WWW.Example.ORG",
+			expected: "other",
+		},
+		"TestTrailingDot": {
+			host:     "www.example.org.",
+			expected: "other",
+		},
+		"TestZoneNameWithoutTrailingDot": {
+			host:     "www.ingressd.syscll.org",
+			expected: "sub",
+		},
+		"TestPublicZone": {
+			host:     "www.syscll.org",
+			zoneType: zoneTypePublic,
+			expected: "public",
+		},
+		"TestPrivateZone": {
+			host:     "www.syscll.org",
+			zoneType: zoneTypePrivate,
+			expected: "private",
+		},
+		"TestAmbiguousZoneError": {
+			host: "www.syscll.org",
+			err:  "2 hosted zones named syscll.org match: www.syscll.org, set a zone type, vpc id or zone id",
+		},
+		"TestPrivateFallsBackToParent": {
+			host:     "a.ingressd.syscll.org",
+			zoneType: zoneTypePrivate,
+			expected: "private",
+		},
+		"TestVPCMatch": {
+			host:     "api.internal.example.org",
+			vpcID:    "vpc-2",
+			expected: "vpc-zone",
+		},
+		"TestVPCNotAssociatedError": {
+			host:  "www.syscll.org",
+			vpcID: "vpc-2",
+			err:   "no zone id found for: www.syscll.org",
+		},
+		"TestNoMatchError": {
+			host: "syscll.com",
+			err:  "no zone id found for: syscll.com",
+		},
+	}
+
+	for name, test := range testTable {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			zone, err := matchRoute53HostedZone(test.host, zones, test.zoneType, test.vpcID, vpcs)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error: '%s', got: '%v'", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected error: nil, got: %v", err)
+			}
+			if id := aws.StringValue(zone.Id); id != test.expected {
+				t.Errorf("expected zone id: '%s', got: '%s'", test.expected, id)
+			}
+		})
+	}
+}
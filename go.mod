@@ -6,4 +6,5 @@ require (
 	github.com/aws/aws-sdk-go v1.36.19
 	github.com/prometheus/client_golang v1.9.0
 	github.com/rs/zerolog v1.20.0
+	gopkg.in/yaml.v2 v2.3.0
 )